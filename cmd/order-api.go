@@ -7,7 +7,10 @@ import (
 	"GenericEndpoint/internal/configs"
 	"GenericEndpoint/internal/repository"
 	"GenericEndpoint/pkg"
+	"context"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"net/http"
 	"time"
@@ -27,22 +30,50 @@ import (
 
 // @host      localhost:8011
 // @BasePath  /api
-func StartOrderAPI() {
+func StartOrderAPI(config configs.Config) {
 	// Echo instance
 	e := echo.New()
 
+	// Request id is set before the error middleware, so problem documents can reference it
+	e.Use(middleware.RequestID())
+
 	// Error Middleware
 	e.Use(pkg.ErrorHandlerMiddleware)
 
-	// Get config
-	config := configs.GetConfig("test")
-
 	// Create repo and service
-	mongoOrderCollection := configs.ConnectDB(config.Database.Connection).Database(config.Database.DatabaseName).Collection(config.Database.OrderCollectionName)
-	OrderRepository := repository.NewRepository(mongoOrderCollection)
-	OrderService := order_api.NewService(OrderRepository)
+	mongoDatabase := configs.ConnectDB(config.Database.Connection).Database(config.Database.DatabaseName)
+	mongoOrderCollection := mongoDatabase.Collection(config.Database.OrderCollectionName)
+	mongoOutboxCollection := mongoDatabase.Collection(config.Database.OutboxCollectionName)
+	mongoAuditCollection := mongoDatabase.Collection(config.Database.AuditCollectionName)
+	OrderRepository := repository.NewRepository(mongoOrderCollection, mongoOutboxCollection, mongoAuditCollection)
+	if err := OrderRepository.EnsureIndexes(); err != nil {
+		log.Fatalf("Error creating the indexes of the repository: %v", err)
+	}
+	mongoIdempotencyCollection := mongoDatabase.Collection(config.Database.IdempotencyCollectionName)
+	IdempotencyRepository := repository.NewIdempotencyRepository(mongoIdempotencyCollection)
+	if err := IdempotencyRepository.EnsureIndexes(config.Idempotency.TTL); err != nil {
+		log.Fatalf("Error creating the indexes of idempotency keys: %v", err)
+	}
+	OrderService := order_api.NewService(OrderRepository, IdempotencyRepository)
 
 	OrderElastic := order_api.NewElasticService(&config)
+	if err := OrderElastic.EnsureOrderIndex(); err != nil {
+		log.Fatalf("Error creating the order index: %v", err)
+	}
+	// Searches on an index with dynamic mappings return wrong results or fail, it is replaced by the reindex
+	// command before the API is started
+	if err := OrderElastic.VerifyOrderIndex(); err != nil {
+		log.Fatalf("Order index is not valid: %v", err)
+	}
+
+	// Apply order changes to elasticsearch in the background
+	dispatcherContext, stopDispatcher := context.WithCancel(context.Background())
+	OutboxDispatcher := order_api.NewOutboxDispatcher(OrderRepository, OrderElastic)
+	go OutboxDispatcher.Start(dispatcherContext)
+
+	// Purge soft deleted orders after the retention
+	PurgeJob := order_api.NewPurgeJob(OrderRepository, config.SoftDelete.Retention, config.SoftDelete.PurgeInterval)
+	go PurgeJob.Start(dispatcherContext)
 
 	// Create handler
 	handler.NewHandler(e, OrderService, OrderElastic)
@@ -61,4 +92,5 @@ func StartOrderAPI() {
 
 	// Graceful Shutdown
 	pkg.GracefulShutdown(e, 10*time.Second)
+	stopDispatcher()
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"GenericEndpoint/internal/apps/order-api"
+	"GenericEndpoint/internal/configs"
+	"GenericEndpoint/internal/repository"
+	"encoding/json"
+	"github.com/labstack/gommon/log"
+	"os"
+)
+
+// StartOrderCheck compares the orders in MongoDB and Elasticsearch and prints the report,
+// with repair the differences are fixed from MongoDB
+func StartOrderCheck(config configs.Config, repair bool) {
+	// Create repo and service
+	mongoDatabase := configs.ConnectDB(config.Database.Connection).Database(config.Database.DatabaseName)
+	mongoOrderCollection := mongoDatabase.Collection(config.Database.OrderCollectionName)
+	mongoOutboxCollection := mongoDatabase.Collection(config.Database.OutboxCollectionName)
+	mongoAuditCollection := mongoDatabase.Collection(config.Database.AuditCollectionName)
+	OrderRepository := repository.NewRepository(mongoOrderCollection, mongoOutboxCollection, mongoAuditCollection)
+
+	OrderElastic := order_api.NewElasticService(&config)
+
+	report, err := order_api.NewConsistencyChecker(OrderRepository, OrderElastic).Check(repair)
+	if err != nil {
+		log.Fatalf("Consistency check failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Error writing the report: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"GenericEndpoint/internal/apps/order-api"
+	"GenericEndpoint/internal/configs"
+	"GenericEndpoint/internal/repository"
+	"context"
+	"github.com/labstack/gommon/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// StartOrderIndexer runs the change stream indexer until the process is interrupted
+func StartOrderIndexer(config configs.Config) {
+	// Create repo and service
+	mongoDatabase := configs.ConnectDB(config.Database.Connection).Database(config.Database.DatabaseName)
+	mongoOrderCollection := mongoDatabase.Collection(config.Database.OrderCollectionName)
+	mongoOutboxCollection := mongoDatabase.Collection(config.Database.OutboxCollectionName)
+	mongoAuditCollection := mongoDatabase.Collection(config.Database.AuditCollectionName)
+	mongoResumeTokenCollection := mongoDatabase.Collection(config.Database.ResumeTokenCollectionName)
+	OrderRepository := repository.NewRepository(mongoOrderCollection, mongoOutboxCollection, mongoAuditCollection)
+	ResumeTokenRepository := repository.NewResumeTokenRepository(mongoResumeTokenCollection)
+
+	OrderElastic := order_api.NewElasticService(&config)
+	if err := OrderElastic.EnsureOrderIndex(); err != nil {
+		log.Fatalf("Error creating the order index: %v", err)
+	}
+	// Searches on an index with dynamic mappings return wrong results, it is replaced by the reindex command
+	if err := OrderElastic.VerifyOrderIndex(); err != nil {
+		log.Warnf("Order index is not valid: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	Indexer := order_api.NewChangeStreamIndexer(OrderRepository, ResumeTokenRepository, OrderElastic)
+	if err := Indexer.Start(ctx); err != nil {
+		log.Fatalf("Indexer is stopped with an error: %v", err)
+	}
+}
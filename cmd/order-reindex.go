@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"GenericEndpoint/internal/apps/order-api"
+	"GenericEndpoint/internal/configs"
+	"GenericEndpoint/internal/repository"
+	"github.com/labstack/gommon/log"
+)
+
+// StartOrderReindex copies all orders to a new version of the order index and moves the alias to it
+func StartOrderReindex(config configs.Config) {
+	// Create repo and service
+	mongoDatabase := configs.ConnectDB(config.Database.Connection).Database(config.Database.DatabaseName)
+	mongoOrderCollection := mongoDatabase.Collection(config.Database.OrderCollectionName)
+	mongoOutboxCollection := mongoDatabase.Collection(config.Database.OutboxCollectionName)
+	mongoAuditCollection := mongoDatabase.Collection(config.Database.AuditCollectionName)
+	OrderRepository := repository.NewRepository(mongoOrderCollection, mongoOutboxCollection, mongoAuditCollection)
+
+	OrderElastic := order_api.NewElasticService(&config)
+
+	index, err := order_api.NewReindexer(OrderRepository, OrderElastic).Reindex()
+	if err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+
+	log.Infof("Orders are reindexed to {%s}", index)
+}
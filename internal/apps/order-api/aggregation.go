@@ -0,0 +1,146 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxBuckets limits the number of groups returned by an aggregation
+const MaxBuckets = 1000
+
+// Types of aggregation metrics
+const (
+	MetricCount = "count"
+	MetricSum   = "sum"
+	MetricAvg   = "avg"
+	MetricMin   = "min"
+	MetricMax   = "max"
+)
+
+// Fields which can be used by metrics, product fields are calculated over every product line
+var metricFields = map[string]bool{"total": true, "product.price": true, "product.quantity": true}
+
+var histogramIntervals = map[string]bool{"hour": true, "day": true, "week": true, "month": true, "year": true}
+
+// Aggregation is the parsed and validated form of OrderAggregateRequest
+type Aggregation struct {
+	Filter    Expr
+	GroupBy   []string
+	Metrics   []Metric
+	Histogram *Histogram
+}
+
+type Metric struct {
+	Name  string
+	Type  string
+	Field string
+}
+
+// Histogram groups dates by calendar intervals in the time zone
+type Histogram struct {
+	Field    string
+	Interval string
+	TimeZone string
+}
+
+// IsProductMetric reports whether the metric is calculated over product lines instead of orders
+func (m Metric) IsProductMetric() bool {
+	return strings.HasPrefix(m.Field, "product.")
+}
+
+// ParseOrderAggregateRequest validates the request and converts it to an Aggregation
+func ParseOrderAggregateRequest(req OrderAggregateRequest) (*Aggregation, error) {
+	filter, err := parseFilter(req.OrderFilter)
+	if err != nil {
+		return nil, err
+	}
+	excludeDeleted(filter, req.IncludeDeleted)
+
+	aggregation := &Aggregation{Filter: filter}
+
+	seen := make(map[string]bool)
+	for _, field := range req.GroupBy {
+		if _, err := validateValueField(field); err != nil {
+			return nil, err
+		}
+		// Buckets of product lines would count lines instead of orders
+		if nestedPath(field) != "" {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("orders cannot be grouped by the product line field %q", field)}
+		}
+		if seen[field] {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("orders are grouped by %q more than once", field)}
+		}
+		seen[field] = true
+		aggregation.GroupBy = append(aggregation.GroupBy, field)
+	}
+
+	if req.DateHistogram != nil {
+		histogram := &Histogram{
+			Field:    req.DateHistogram.Field,
+			Interval: strings.ToLower(req.DateHistogram.Interval),
+			TimeZone: req.DateHistogram.TimeZone,
+		}
+
+		fieldType, err := validateValueField(histogram.Field)
+		if err != nil {
+			return nil, err
+		}
+		if fieldType != fieldDate {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("date histogram needs a date field, %q is not", histogram.Field)}
+		}
+		if seen[histogram.Field] {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("orders are grouped by %q more than once", histogram.Field)}
+		}
+		if !histogramIntervals[histogram.Interval] {
+			return nil, &pkg.BadRequestError{Message: "histogram interval must be hour, day, week, month or year"}
+		}
+		if histogram.TimeZone == "" {
+			histogram.TimeZone = "UTC"
+		}
+		if _, err := time.LoadLocation(histogram.TimeZone); err != nil {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("time zone %q is not valid", histogram.TimeZone)}
+		}
+
+		aggregation.Histogram = histogram
+	}
+
+	names := make(map[string]bool)
+	for _, aggregateMetric := range req.Metrics {
+		metric := Metric{
+			Name:  aggregateMetric.Name,
+			Type:  strings.ToLower(aggregateMetric.Type),
+			Field: aggregateMetric.Field,
+		}
+
+		switch metric.Type {
+		case MetricCount:
+			metric.Field = ""
+		case MetricSum, MetricAvg, MetricMin, MetricMax:
+			if !metricFields[metric.Field] {
+				return nil, &pkg.BadRequestError{Message: fmt.Sprintf("%s metric needs one of the fields total, product.price or product.quantity", metric.Type)}
+			}
+		default:
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("metric type %q is not supported", aggregateMetric.Type)}
+		}
+
+		if metric.Name == "" {
+			metric.Name = metric.Type
+			if metric.Field != "" {
+				metric.Name += "_" + strings.ReplaceAll(metric.Field, ".", "_")
+			}
+		}
+		if strings.ContainsAny(metric.Name, ".$") {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("metric name %q cannot contain . or $", metric.Name)}
+		}
+		if names[metric.Name] {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("metric name %q is used more than once", metric.Name)}
+		}
+		names[metric.Name] = true
+
+		aggregation.Metrics = append(aggregation.Metrics, metric)
+	}
+
+	return aggregation, nil
+}
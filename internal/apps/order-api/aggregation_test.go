@@ -0,0 +1,247 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseAggregation parses the json body of an aggregation request like the handler
+func parseAggregation(t *testing.T, body string) (*Aggregation, error) {
+	t.Helper()
+
+	var req OrderAggregateRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return ParseOrderAggregateRequest(req)
+}
+
+func TestParseOrderAggregateRequestRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"unknown group field", `{"group_by": ["unknown"]}`, `"unknown"`},
+		{"group by an object", `{"group_by": ["product"]}`, `"product"`},
+		{"group by a product line field", `{"group_by": ["product.name"]}`, "product line field"},
+		{"group by a field twice", `{"group_by": ["city", "city"]}`, "more than once"},
+		{"histogram of a number", `{"date_histogram": {"field": "total", "interval": "day"}}`, "date field"},
+		{"histogram and group by the same field", `{"group_by": ["createdAt"], "date_histogram": {"field": "createdAt", "interval": "day"}}`, "more than once"},
+		{"unknown interval", `{"date_histogram": {"field": "createdAt", "interval": "minute"}}`, "interval"},
+		{"unknown time zone", `{"date_histogram": {"field": "createdAt", "interval": "day", "time_zone": "Mars/Base"}}`, "time zone"},
+		{"metric of another field", `{"metrics": [{"type": "sum", "field": "version"}]}`, "total, product.price or product.quantity"},
+		{"unknown metric", `{"metrics": [{"type": "median", "field": "total"}]}`, `"median"`},
+		{"metric name with a dot", `{"metrics": [{"name": "a.b", "type": "count"}]}`, "cannot contain"},
+		{"metric name used twice", `{"metrics": [{"type": "count"}, {"type": "count"}]}`, "more than once"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseAggregation(t, test.body)
+
+			var badRequestError *pkg.BadRequestError
+			if !errors.As(err, &badRequestError) {
+				t.Fatalf("expected a bad request, got %v", err)
+			}
+			if !strings.Contains(badRequestError.Message, test.message) {
+				t.Errorf("expected %q in the message, got %q", test.message, badRequestError.Message)
+			}
+		})
+	}
+}
+
+func TestAggregationsAreCompiledToBothBackends(t *testing.T) {
+	// MongoDB pipelines are written as relaxed extended JSON under a pipeline key
+	tests := []struct {
+		name    string
+		body    string
+		mongo   string
+		elastic string
+	}{
+		{
+			name: "group by a field",
+			body: `{"include_deleted": true, "group_by": ["status"], "metrics": [{"type": "count"}, {"type": "sum", "field": "total"}]}`,
+			mongo: `{"pipeline": [
+				{"$match": {}},
+				{"$group": {"_id": {"g0": "$status"}, "count": {"$sum": 1}, "_m1": {"$sum": "$total"}}},
+				{"$sort": {"_id": 1}},
+				{"$limit": 1000},
+				{"$project": {"_id": 0, "key": "$_id", "count": 1, "metrics": {"count": "$count", "sum_total": "$_m1"}}}
+			]}`,
+			elastic: `{
+				"query": {"match_all": {}},
+				"size": 0,
+				"track_total_hits": true,
+				"aggs": {"g0": {
+					"terms": {"field": "status", "size": 1000, "order": {"_key": "asc"}},
+					"aggs": {"m1": {"sum": {"field": "total"}}}
+				}}
+			}`,
+		},
+		{
+			name: "group by fields and a date histogram",
+			body: `{"include_deleted": true, "exact_filters": {"city": ["Ankara"]}, "group_by": ["city", "status"], "date_histogram": {"field": "createdAt", "interval": "month", "time_zone": "Europe/Istanbul"}}`,
+			mongo: `{"pipeline": [
+				{"$match": {"city": {"$in": ["Ankara"]}}},
+				{"$group": {"_id": {"g0": "$city", "g1": "$status", "h": {"$dateTrunc": {"date": "$createdAt", "unit": "month", "timezone": "Europe/Istanbul", "startOfWeek": "monday"}}}, "count": {"$sum": 1}}},
+				{"$sort": {"_id": 1}},
+				{"$limit": 1000},
+				{"$project": {"_id": 0, "key": "$_id", "count": 1}}
+			]}`,
+			elastic: `{
+				"query": {"terms": {"city": ["Ankara"]}},
+				"size": 0,
+				"track_total_hits": true,
+				"aggs": {"g0": {
+					"terms": {"field": "city", "size": 1000, "order": {"_key": "asc"}},
+					"aggs": {"g1": {
+						"terms": {"field": "status", "size": 1000, "order": {"_key": "asc"}},
+						"aggs": {"h": {"date_histogram": {"field": "createdAt", "calendar_interval": "month", "time_zone": "Europe/Istanbul", "min_doc_count": 1}}}
+					}}
+				}}
+			}`,
+		},
+		{
+			name: "metrics of product lines",
+			body: `{"include_deleted": true, "metrics": [{"name": "avg_price", "type": "avg", "field": "product.price"}, {"type": "max", "field": "product.quantity"}]}`,
+			mongo: `{"pipeline": [
+				{"$match": {}},
+				{"$addFields": {"_m0_sum": {"$sum": "$product.price"}, "_m0_count": {"$size": {"$ifNull": ["$product", []]}}, "_m1": {"$max": "$product.quantity"}}},
+				{"$group": {"_id": null, "count": {"$sum": 1}, "_m0_sum": {"$sum": "$_m0_sum"}, "_m0_count": {"$sum": "$_m0_count"}, "_m1": {"$max": "$_m1"}}},
+				{"$sort": {"_id": 1}},
+				{"$limit": 1000},
+				{"$project": {"_id": 0, "key": "$_id", "count": 1, "metrics": {
+					"avg_price": {"$cond": [{"$eq": ["$_m0_count", 0]}, null, {"$divide": ["$_m0_sum", "$_m0_count"]}]},
+					"max_product_quantity": "$_m1"
+				}}}
+			]}`,
+			elastic: `{
+				"query": {"match_all": {}},
+				"size": 0,
+				"track_total_hits": true,
+				"aggs": {
+					"m0": {"nested": {"path": "product"}, "aggs": {"v": {"avg": {"field": "product.price"}}}},
+					"m1": {"nested": {"path": "product"}, "aggs": {"v": {"max": {"field": "product.quantity"}}}}
+				}
+			}`,
+		},
+		{
+			name: "group by the keyword sub-field of a text field",
+			body: `{"include_deleted": true, "group_by": ["addressDetail"]}`,
+			mongo: `{"pipeline": [
+				{"$match": {}},
+				{"$group": {"_id": {"g0": "$addressDetail"}, "count": {"$sum": 1}}},
+				{"$sort": {"_id": 1}},
+				{"$limit": 1000},
+				{"$project": {"_id": 0, "key": "$_id", "count": 1}}
+			]}`,
+			elastic: `{
+				"query": {"match_all": {}},
+				"size": 0,
+				"track_total_hits": true,
+				"aggs": {"g0": {"terms": {"field": "addressDetail.keyword", "size": 1000, "order": {"_key": "asc"}}}}
+			}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aggregation, err := parseAggregation(t, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mongoCompiler MongoCompiler
+			filter, err := mongoCompiler.Filter(&Query{Filter: aggregation.Filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mongoJSON, err := bson.MarshalExtJSON(bson.M{"pipeline": mongoCompiler.AggregationPipeline(aggregation, filter)}, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := normalizeJSON(t, []byte(test.mongo)), normalizeJSON(t, mongoJSON); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected MongoDB pipeline %s, got %s", test.mongo, mongoJSON)
+			}
+
+			elasticBody, err := ElasticCompiler{}.AggregationBody(aggregation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			elasticJSON, err := json.Marshal(elasticBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := normalizeJSON(t, []byte(test.elastic)), normalizeJSON(t, elasticJSON); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected Elasticsearch body %s, got %s", test.elastic, elasticJSON)
+			}
+		})
+	}
+}
+
+func TestAggregateBucketsAreTheSameOnBothBackends(t *testing.T) {
+	aggregation, err := parseAggregation(t, `{
+		"group_by": ["status"],
+		"date_histogram": {"field": "createdAt", "interval": "month"},
+		"metrics": [{"type": "count"}, {"type": "sum", "field": "total"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	may := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var documents []bson.Raw
+	for _, document := range []bson.M{
+		{"key": bson.M{"g0": "created", "h": primitive.NewDateTimeFromTime(may)}, "count": int64(2), "metrics": bson.M{"count": int64(2), "sum_total": 30.0}},
+		{"key": bson.M{"g0": "created", "h": primitive.NewDateTimeFromTime(june)}, "count": int64(1), "metrics": bson.M{"count": int64(1), "sum_total": 5.0}},
+	} {
+		data, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, data)
+	}
+
+	var mongoCompiler MongoCompiler
+	mongoBuckets, err := mongoCompiler.AggregateBuckets(aggregation, documents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty buckets of the histogram are left out like in MongoDB
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"hits": {"total": {"value": 3}},
+		"aggregations": {"g0": {"buckets": [{"key": "created", "doc_count": 3, "h": {"buckets": [
+			{"key": 1682899200000, "doc_count": 2, "m1": {"value": 30}},
+			{"key": 1684000000000, "doc_count": 0, "m1": {"value": 0}},
+			{"key": 1685577600000, "doc_count": 1, "m1": {"value": 5}}
+		]}}]}}
+	}`), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	elasticBuckets := ElasticCompiler{}.AggregateBuckets(aggregation, response)
+
+	expected := []AggregateBucket{
+		{Key: map[string]interface{}{"status": "created", "createdAt": "2023-05-01T00:00:00Z"}, Count: 2, Metrics: map[string]interface{}{"count": int64(2), "sum_total": 30.0}},
+		{Key: map[string]interface{}{"status": "created", "createdAt": "2023-06-01T00:00:00Z"}, Count: 1, Metrics: map[string]interface{}{"count": int64(1), "sum_total": 5.0}},
+	}
+	if !reflect.DeepEqual(mongoBuckets, expected) {
+		t.Errorf("expected MongoDB buckets %+v, got %+v", expected, mongoBuckets)
+	}
+	if !reflect.DeepEqual(elasticBuckets, expected) {
+		t.Errorf("expected Elasticsearch buckets %+v, got %+v", expected, elasticBuckets)
+	}
+}
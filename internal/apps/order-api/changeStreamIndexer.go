@@ -0,0 +1,148 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/internal/repository"
+	"context"
+	"errors"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// changeStreamHistoryLost is the error code returned by MongoDB when the resume token is no longer in the oplog
+const changeStreamHistoryLost = 286
+
+// ChangeStreamIndexer tails the order collection and applies every change to Elasticsearch, so orders written
+// by other applications are indexed too. The resume token is saved after every change, a restarted indexer
+// continues from the last applied change.
+type ChangeStreamIndexer struct {
+	Name           string
+	Repository     *repository.Repository
+	ResumeTokens   *repository.ResumeTokenRepository
+	ElasticService *ElasticService
+	// Waiting time before a failed change is applied again, it is doubled up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+}
+
+// changeEvent is the part of a change stream event used by the indexer
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *models.Order `bson:"fullDocument"`
+}
+
+func NewChangeStreamIndexer(repository *repository.Repository, resumeTokens *repository.ResumeTokenRepository, elasticService *ElasticService) *ChangeStreamIndexer {
+	indexer := &ChangeStreamIndexer{
+		Name:             "order-elasticsearch-indexer",
+		Repository:       repository,
+		ResumeTokens:     resumeTokens,
+		ElasticService:   elasticService,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: time.Minute,
+	}
+	return indexer
+}
+
+// Start applies changes until the context is cancelled or the stream fails
+func (i *ChangeStreamIndexer) Start(ctx context.Context) error {
+	stream, err := i.watch(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	log.Infof("Indexer {%s} is watching orders", i.Name)
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+
+		// The collection is dropped or renamed, the stream cannot continue
+		if event.OperationType == "invalidate" {
+			return errors.New("change stream of orders is invalidated")
+		}
+
+		// Only a cancelled context stops the retries
+		if err := i.applyWithRetry(ctx, event); err != nil {
+			break
+		}
+
+		if err := i.ResumeTokens.Save(i.Name, stream.ResumeToken()); err != nil {
+			log.Errorf("Error saving resume token of indexer {%s}: %v", i.Name, err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Infof("Indexer {%s} is stopped", i.Name)
+		return nil
+	}
+
+	return stream.Err()
+}
+
+// watch opens the stream after the saved resume token, or at the current time if there is no token
+// or the token is too old. Changes missed in that case need a reindex.
+func (i *ChangeStreamIndexer) watch(ctx context.Context) (*mongo.ChangeStream, error) {
+	token, err := i.ResumeTokens.Get(i.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := i.Repository.WatchOrders(ctx, token)
+
+	var serverError mongo.ServerError
+	if token != nil && errors.As(err, &serverError) && serverError.HasErrorCode(changeStreamHistoryLost) {
+		log.Warnf("Resume token of indexer {%s} is no longer in the oplog, orders changed since then must be reindexed", i.Name)
+		if err := i.ResumeTokens.Delete(i.Name); err != nil {
+			return nil, err
+		}
+		return i.Repository.WatchOrders(ctx, nil)
+	}
+
+	return stream, err
+}
+
+// applyWithRetry does not skip a change which cannot be applied, so the resume token never passes it
+func (i *ChangeStreamIndexer) applyWithRetry(ctx context.Context, event changeEvent) error {
+	interval := i.RetryInterval
+	for {
+		err := i.apply(event)
+		if err == nil {
+			return nil
+		}
+
+		log.Warnf("Change {%s} of order {%v} will be applied again in %v: %v", event.OperationType, event.DocumentKey.ID, interval, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > i.MaxRetryInterval {
+			interval = i.MaxRetryInterval
+		}
+	}
+}
+
+func (i *ChangeStreamIndexer) apply(event changeEvent) error {
+	switch event.OperationType {
+	case "insert", "update", "replace":
+		// The order is deleted before the update is looked up
+		if event.FullDocument == nil {
+			return i.ElasticService.DeleteOrderFromElasticsearch(event.DocumentKey.ID)
+		}
+		return i.ElasticService.SaveOrderToElasticsearch(*event.FullDocument)
+	case "delete":
+		return i.ElasticService.DeleteOrderFromElasticsearch(event.DocumentKey.ID)
+	default:
+		return nil
+	}
+}
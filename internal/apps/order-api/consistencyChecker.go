@@ -0,0 +1,224 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/internal/repository"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+)
+
+// MaxReportedIDs limits the IDs listed for every kind of difference, counts include every order
+const MaxReportedIDs = 1000
+
+// ConsistencyReport lists the differences between MongoDB and the Elasticsearch index. Missing orders are not
+// indexed, extra orders are indexed but deleted from MongoDB and stale orders have another updatedAt in the index.
+type ConsistencyReport struct {
+	MongoCount   int64    `json:"mongoCount"`
+	ElasticCount int64    `json:"elasticCount"`
+	MissingCount int64    `json:"missingCount"`
+	ExtraCount   int64    `json:"extraCount"`
+	StaleCount   int64    `json:"staleCount"`
+	Missing      []string `json:"missing"`
+	Extra        []string `json:"extra"`
+	Stale        []string `json:"stale"`
+	Repaired     int64    `json:"repaired"`
+}
+
+// ConsistencyChecker compares the id and updatedAt of every order in MongoDB and Elasticsearch. Both stores
+// are read in id order in batches, so the orders are compared like a merge join without loading them all.
+type ConsistencyChecker struct {
+	Repository     *repository.Repository
+	ElasticService *ElasticService
+	BatchSize      int
+}
+
+func NewConsistencyChecker(repository *repository.Repository, elasticService *ElasticService) *ConsistencyChecker {
+	checker := &ConsistencyChecker{
+		Repository:     repository,
+		ElasticService: elasticService,
+		BatchSize:      1000,
+	}
+	return checker
+}
+
+// orderStream reads the id and updatedAt of orders batch by batch
+type orderStream struct {
+	next    func(afterID string) ([]models.Order, error)
+	batch   []models.Order
+	afterID string
+	done    bool
+}
+
+// peek returns the current order, nil is returned at the end of the stream
+func (s *orderStream) peek() (*models.Order, error) {
+	if len(s.batch) == 0 && !s.done {
+		batch, err := s.next(s.afterID)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			s.done = true
+		} else {
+			s.batch = batch
+			s.afterID = batch[len(batch)-1].ID
+		}
+	}
+
+	if len(s.batch) == 0 {
+		return nil, nil
+	}
+
+	return &s.batch[0], nil
+}
+
+func (s *orderStream) pop() {
+	s.batch = s.batch[1:]
+}
+
+// orderDifference is the kind of a difference found by compareOrderStreams
+type orderDifference int
+
+const (
+	orderMissing orderDifference = iota
+	orderExtra
+	orderStale
+)
+
+// Check compares the stores, with repair missing and stale orders are indexed again from MongoDB and extra
+// orders are deleted from the index. Without repair only the counts and the first ids are kept.
+func (c *ConsistencyChecker) Check(repair bool) (*ConsistencyReport, error) {
+	report := &ConsistencyReport{Missing: []string{}, Extra: []string{}, Stale: []string{}}
+
+	mongoStream := &orderStream{next: func(afterID string) ([]models.Order, error) {
+		return c.Repository.GetUpdateTimesAfterID(afterID, int64(c.BatchSize))
+	}}
+	elasticStream := &orderStream{next: func(afterID string) ([]models.Order, error) {
+		return c.ElasticService.GetUpdateTimesAfterID(afterID, c.BatchSize)
+	}}
+
+	// Orders to index are repaired in batches, so they are not collected for the whole check
+	var toIndex []string
+	err := compareOrderStreams(mongoStream, elasticStream, report, func(difference orderDifference, id string) error {
+		if !repair {
+			return nil
+		}
+
+		if difference == orderExtra {
+			if err := c.ElasticService.DeleteOrderFromElasticsearch(id); err != nil {
+				return err
+			}
+			report.Repaired++
+			return nil
+		}
+
+		toIndex = append(toIndex, id)
+		if len(toIndex) >= c.BatchSize {
+			if err := c.reindex(toIndex, report); err != nil {
+				return err
+			}
+			toIndex = toIndex[:0]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toIndex) > 0 {
+		if err := c.reindex(toIndex, report); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("Consistency check: %d missing, %d extra and %d stale orders, %d repaired", report.MissingCount, report.ExtraCount, report.StaleCount, report.Repaired)
+
+	return report, nil
+}
+
+// compareOrderStreams reads both streams in id order like a merge join, the differences are counted in the report
+// and passed to found one by one
+func compareOrderStreams(mongoStream *orderStream, elasticStream *orderStream, report *ConsistencyReport, found func(difference orderDifference, id string) error) error {
+	for {
+		mongoOrder, err := mongoStream.peek()
+		if err != nil {
+			return err
+		}
+		elasticOrder, err := elasticStream.peek()
+		if err != nil {
+			return err
+		}
+		if mongoOrder == nil && elasticOrder == nil {
+			return nil
+		}
+
+		// Both stores compare ids in byte order
+		comparison := 0
+		switch {
+		case elasticOrder == nil:
+			comparison = -1
+		case mongoOrder == nil:
+			comparison = 1
+		default:
+			comparison = strings.Compare(mongoOrder.ID, elasticOrder.ID)
+		}
+
+		switch {
+		case comparison < 0:
+			report.MongoCount++
+			report.MissingCount++
+			report.Missing = appendReported(report.Missing, mongoOrder.ID)
+			if err := found(orderMissing, mongoOrder.ID); err != nil {
+				return err
+			}
+			mongoStream.pop()
+		case comparison > 0:
+			report.ElasticCount++
+			report.ExtraCount++
+			report.Extra = appendReported(report.Extra, elasticOrder.ID)
+			if err := found(orderExtra, elasticOrder.ID); err != nil {
+				return err
+			}
+			elasticStream.pop()
+		default:
+			report.MongoCount++
+			report.ElasticCount++
+			// MongoDB keeps milliseconds of dates
+			if mongoOrder.UpdatedAt.UnixMilli() != elasticOrder.UpdatedAt.UnixMilli() {
+				report.StaleCount++
+				report.Stale = appendReported(report.Stale, mongoOrder.ID)
+				if err := found(orderStale, mongoOrder.ID); err != nil {
+					return err
+				}
+			}
+			mongoStream.pop()
+			elasticStream.pop()
+		}
+	}
+}
+
+// reindex indexes the current state of the orders, orders deleted since the check are skipped
+func (c *ConsistencyChecker) reindex(ids []string, report *ConsistencyReport) error {
+	orders, err := c.Repository.GetOrdersWithFilter(bson.M{"_id": bson.M{"$in": ids}}, nil)
+	if err != nil {
+		return err
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+
+	if err := c.ElasticService.BulkIndexOrders(c.ElasticService.Config.Elasticsearch.AliasName["Order"], orders); err != nil {
+		return err
+	}
+	report.Repaired += int64(len(orders))
+
+	return nil
+}
+
+func appendReported(ids []string, id string) []string {
+	if len(ids) >= MaxReportedIDs {
+		return ids
+	}
+
+	return append(ids, id)
+}
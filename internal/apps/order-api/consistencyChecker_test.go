@@ -0,0 +1,135 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sliceStream returns the orders after the id in batches of the size, like the stores sorted by id
+func sliceStream(orders []models.Order, batchSize int) *orderStream {
+	return &orderStream{next: func(afterID string) ([]models.Order, error) {
+		batch := make([]models.Order, 0, batchSize)
+		for _, order := range orders {
+			if order.ID > afterID && len(batch) < batchSize {
+				batch = append(batch, order)
+			}
+		}
+		return batch, nil
+	}}
+}
+
+func TestCompareOrderStreams(t *testing.T) {
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	order := func(id string, updatedAt time.Time) models.Order {
+		return models.Order{ID: id, UpdatedAt: updatedAt}
+	}
+
+	type difference struct {
+		kind orderDifference
+		id   string
+	}
+
+	tests := []struct {
+		name        string
+		mongo       []models.Order
+		elastic     []models.Order
+		differences []difference
+		report      ConsistencyReport
+	}{
+		{
+			name:   "empty stores",
+			report: ConsistencyReport{},
+		},
+		{
+			name:    "same orders",
+			mongo:   []models.Order{order("a", now), order("b", now), order("c", now)},
+			elastic: []models.Order{order("a", now), order("b", now), order("c", now)},
+			report:  ConsistencyReport{MongoCount: 3, ElasticCount: 3},
+		},
+		{
+			name: "missing, extra and stale orders",
+			mongo: []models.Order{
+				order("a", now), order("b", now), order("d", now), order("e", now.Add(time.Second)),
+			},
+			elastic: []models.Order{
+				order("a", now), order("c", now), order("d", now), order("e", now), order("f", now),
+			},
+			differences: []difference{{orderMissing, "b"}, {orderExtra, "c"}, {orderStale, "e"}, {orderExtra, "f"}},
+			report: ConsistencyReport{
+				MongoCount: 4, ElasticCount: 5, MissingCount: 1, ExtraCount: 2, StaleCount: 1,
+				Missing: []string{"b"}, Extra: []string{"c", "f"}, Stale: []string{"e"},
+			},
+		},
+		{
+			name:    "dates are compared in milliseconds",
+			mongo:   []models.Order{order("a", now)},
+			elastic: []models.Order{order("a", now.Add(time.Microsecond))},
+			report:  ConsistencyReport{MongoCount: 1, ElasticCount: 1},
+		},
+		{
+			name:        "empty index",
+			mongo:       []models.Order{order("a", now), order("b", now), order("c", now)},
+			differences: []difference{{orderMissing, "a"}, {orderMissing, "b"}, {orderMissing, "c"}},
+			report:      ConsistencyReport{MongoCount: 3, MissingCount: 3, Missing: []string{"a", "b", "c"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := &ConsistencyReport{}
+			var differences []difference
+
+			// Batches of 2 orders, so the join continues over the batches of both stores
+			err := compareOrderStreams(sliceStream(test.mongo, 2), sliceStream(test.elastic, 2), report, func(kind orderDifference, id string) error {
+				differences = append(differences, difference{kind, id})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(differences, test.differences) {
+				t.Errorf("expected differences %v, got %v", test.differences, differences)
+			}
+			if !reflect.DeepEqual(*report, test.report) {
+				t.Errorf("expected report %+v, got %+v", test.report, *report)
+			}
+		})
+	}
+}
+
+func TestCompareOrderStreamsStopsOnErrors(t *testing.T) {
+	failure := errors.New("store is not available")
+	failing := &orderStream{next: func(afterID string) ([]models.Order, error) {
+		return nil, failure
+	}}
+
+	err := compareOrderStreams(sliceStream(nil, 2), failing, &ConsistencyReport{}, func(orderDifference, string) error {
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the error of the stream, got %v", err)
+	}
+
+	// An error of a repair stops the check
+	err = compareOrderStreams(sliceStream([]models.Order{{ID: "a"}}, 2), sliceStream(nil, 2), &ConsistencyReport{}, func(orderDifference, string) error {
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the error of the repair, got %v", err)
+	}
+}
+
+func TestAppendReported(t *testing.T) {
+	var ids []string
+	for i := 0; i < MaxReportedIDs+10; i++ {
+		ids = appendReported(ids, "id")
+	}
+
+	if len(ids) != MaxReportedIDs {
+		t.Errorf("expected %d ids, got %d", MaxReportedIDs, len(ids))
+	}
+}
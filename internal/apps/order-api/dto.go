@@ -1,36 +1,198 @@
 package order_api
 
+import (
+	"GenericEndpoint/internal/models"
+	"time"
+)
+
+// OrderCreateRequest is checked by Validate before the order is created, new orders are always created
 type OrderCreateRequest struct {
-	UserID        string `json:"userId" bson:"userId"`
-	Status        string `json:"status" bson:"status"`
-	City          string `json:"city" bson:"city"`
-	AddressDetail string `json:"addressDetail" bson:"addressDetail"`
-	Product       []struct {
-		Name     string  `json:"name" bson:"name"`
-		Quantity int     `json:"quantity" bson:"quantity"`
-		Price    float64 `json:"price" bson:"price"`
-	} `json:"product" bson:"product"`
+	UserID        string               `json:"userId" bson:"userId" validate:"required,max=64"`
+	Status        string               `json:"status" bson:"status" validate:"initial_status"`
+	City          string               `json:"city" bson:"city" validate:"required,max=100"`
+	AddressDetail string               `json:"addressDetail" bson:"addressDetail" validate:"required,max=500"`
+	Product       []ProductLineRequest `json:"product" bson:"product" validate:"required,max=100"`
+}
+
+// ProductLineRequest is a product line of a create or update request, free lines have price 0
+type ProductLineRequest struct {
+	Name     string  `json:"name" bson:"name" validate:"required,max=200"`
+	Quantity int     `json:"quantity" bson:"quantity" validate:"min=1,max=10000"`
+	Price    float64 `json:"price" bson:"price" validate:"min=0"`
+}
+
+// OrderBulkCreateRequest creates many orders at once. Ordered stops at the first failed order like an ordered
+// insertMany, otherwise every valid order is inserted.
+type OrderBulkCreateRequest struct {
+	Orders  []OrderCreateRequest `json:"orders"`
+	Ordered bool                 `json:"ordered"`
 }
 
+// OrderUpdateRequest has the rules of OrderCreateRequest
+type OrderUpdateRequest struct {
+	UserID        string               `json:"userId" bson:"userId" validate:"required,max=64"`
+	Status        string               `json:"status" bson:"status" validate:"status"`
+	City          string               `json:"city" bson:"city" validate:"required,max=100"`
+	AddressDetail string               `json:"addressDetail" bson:"addressDetail" validate:"required,max=500"`
+	Product       []ProductLineRequest `json:"product" bson:"product" validate:"required,max=100"`
+}
+
+// OrderPatchRequest changes only the fields which are sent
+type OrderPatchRequest struct {
+	UserID        *string               `json:"userId" validate:"required,max=64"`
+	Status        *string               `json:"status" validate:"status"`
+	City          *string               `json:"city" validate:"required,max=100"`
+	AddressDetail *string               `json:"addressDetail" validate:"required,max=500"`
+	Product       *[]ProductLineRequest `json:"product" validate:"required,max=100"`
+}
+
+type OrderStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// OrderFilter is the filter section shared by generic query and aggregation requests.
+// Soft deleted orders are left out unless IncludeDeleted is set.
+type OrderFilter struct {
+	ExactFilters   map[string][]interface{} `json:"exact_filters"`
+	Match          map[string]interface{}   `json:"match"`
+	Range          map[string]RangeFilter   `json:"range"`
+	Search         []MatchFilter            `json:"search"`
+	ProductLines   []ProductLineFilter      `json:"product_lines"`
+	Query          *QueryGroup              `json:"query"`
+	IncludeDeleted bool                     `json:"include_deleted"`
+}
+
+// OrderGetRequest is the generic query. With MatchingLines the product of every order contains only the lines
+// matching the product_lines filters of the request (filters in query groups are not used for it).
 type OrderGetRequest struct {
+	OrderFilter
+	Fields        []string      `json:"fields"`
+	Sort          []SortRequest `json:"sort"`
+	Limit         int           `json:"limit"`
+	Offset        int           `json:"offset"`
+	Cursor        string        `json:"cursor"`
+	MatchingLines bool          `json:"matching_lines"`
+}
+
+// OrderDeleteByQueryRequest soft deletes the orders matching the filter, it is the body of DELETE /orders/deleteByQuery.
+// Orders are not removed with DeleteMany and _delete_by_query since soft delete was added: they can be restored from
+// the trash like orders deleted one by one, and the purge job removes them after the retention. DryRun returns the matching orders without
+// deleting them. The request fails without deleting if more orders match than MaxDocuments (MaxDeleteByQuery by default).
+type OrderDeleteByQueryRequest struct {
+	OrderFilter
+	DryRun       bool `json:"dry_run"`
+	MaxDocuments int  `json:"max_documents"`
+}
+
+// OrderAggregateRequest groups the filtered orders by fields and an optional date histogram and calculates
+// metrics for every group. Without GroupBy and DateHistogram the metrics are calculated for all orders.
+type OrderAggregateRequest struct {
+	OrderFilter
+	GroupBy       []string          `json:"group_by"`
+	Metrics       []AggregateMetric `json:"metrics"`
+	DateHistogram *DateHistogram    `json:"date_histogram"`
+}
+
+// AggregateMetric is one of count, sum, avg, min or max. Field is total, product.price or product.quantity
+// (count does not need a field), Name is the key of the result and defaults to type_field.
+type AggregateMetric struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Field string `json:"field"`
+}
+
+// DateHistogram groups orders by a date field. Interval is hour, day, week, month or year,
+// TimeZone is an IANA name like Europe/Istanbul (default UTC).
+type DateHistogram struct {
+	Field    string `json:"field"`
+	Interval string `json:"interval"`
+	TimeZone string `json:"time_zone"`
+}
+
+// QueryGroup is a boolean expression over filters. Filters of the group and every And group must match,
+// at least one of the Or groups must match and none of the Not groups may match.
+type QueryGroup struct {
+	ExactFilters map[string][]interface{} `json:"exact_filters"`
+	Range        map[string]RangeFilter   `json:"range"`
+	Search       []MatchFilter            `json:"search"`
+	ProductLines []ProductLineFilter      `json:"product_lines"`
+	And          []QueryGroup             `json:"and"`
+	Or           []QueryGroup             `json:"or"`
+	Not          []QueryGroup             `json:"not"`
+}
+
+// ProductLineFilter matches orders which have a product line matching every condition of the filter, so
+// conditions on name and quantity cannot be matched by two different lines. Fields are name, quantity and price.
+type ProductLineFilter struct {
 	ExactFilters map[string][]interface{} `json:"exact_filters"`
-	Fields       []string                 `json:"fields"`
-	Match        map[string]interface{}   `json:"match"`
-	Sort         map[string]int           `json:"sort"`
+	Range        map[string]RangeFilter   `json:"range"`
+	Search       []MatchFilter            `json:"search"`
+}
+
+// MatchFilter is a full-text condition. Mode is one of match (default), match_phrase, multi_match or fuzzy,
+// multi_match searches every field in Fields while the other modes need exactly one field.
+// Fuzziness can be AUTO, 0, 1 or 2 and is not supported by match_phrase.
+type MatchFilter struct {
+	Fields    []string    `json:"fields"`
+	Query     interface{} `json:"query"`
+	Mode      string      `json:"mode"`
+	Fuzziness string      `json:"fuzziness"`
+}
+
+// SortRequest is one key of the sort order, keys are applied in the given order. Direction is asc (default)
+// or desc, Missing places documents without the field first or last (default).
+type SortRequest struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"`
+	Missing   string `json:"missing"`
+}
+
+// RangeFilter holds the bounds of a range query. Empty bounds are ignored, values can be numbers,
+// dates (RFC3339 or 2006-01-02) or relative dates like "now-7d".
+type RangeFilter struct {
+	Gt  interface{} `json:"gt,omitempty"`
+	Gte interface{} `json:"gte,omitempty"`
+	Lt  interface{} `json:"lt,omitempty"`
+	Lte interface{} `json:"lte,omitempty"`
 }
 
 type OrderResponse struct {
-	ID            string `json:"id,omitempty" bson:"_id"`
-	UserID        string `json:"userId,omitempty" bson:"userId"`
-	Status        string `json:"status,omitempty" bson:"status"`
-	City          string `json:"city,omitempty" bson:"city"`
-	AddressDetail string `json:"addressDetail,omitempty" bson:"addressDetail"`
-	Product       []struct {
-		Name     string  `json:"name" bson:"name"`
-		Quantity int     `json:"quantity" bson:"quantity"`
-		Price    float64 `json:"price" bson:"price"`
-	} `json:"product,omitempty" bson:"product"`
-	Total     float64 `json:"total,omitempty" bson:"total"`
-	CreatedAt string  `json:"createdAt,omitempty" bson:"createdAt"`
-	UpdatedAt string  `json:"updatedAt,omitempty" bson:"updatedAt"`
+	ID            string               `json:"id,omitempty" bson:"_id"`
+	UserID        string               `json:"userId,omitempty" bson:"userId"`
+	Status        string               `json:"status,omitempty" bson:"status"`
+	City          string               `json:"city,omitempty" bson:"city"`
+	AddressDetail string               `json:"addressDetail,omitempty" bson:"addressDetail"`
+	Product       []models.ProductLine `json:"product,omitempty" bson:"product"`
+	Total         float64              `json:"total,omitempty" bson:"total"`
+	CreatedAt     string               `json:"createdAt,omitempty" bson:"createdAt"`
+	UpdatedAt     string               `json:"updatedAt,omitempty" bson:"updatedAt"`
+	StatusHistory map[string]time.Time `json:"statusHistory,omitempty" bson:"statusHistory"`
+	DeletedAt     string               `json:"deletedAt,omitempty" bson:"deletedAt"`
+	Version       int64                `json:"version" bson:"version"`
+}
+
+type AggregateBucket struct {
+	Key     map[string]interface{} `json:"key"`
+	Count   int64                  `json:"count"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+// BulkOrderResult is the result of one order of a bulk create request, Index is the position in the request.
+// An order which is saved but not indexed yet is indexed in the background.
+type BulkOrderResult struct {
+	Index   int    `json:"index"`
+	ID      string `json:"id,omitempty"`
+	Success bool   `json:"success"`
+	Indexed bool   `json:"indexed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteByQueryResult lists the orders matching a delete by query request and the number of soft deleted orders.
+// ElasticIndexed is the number of deleted orders already updated in the index, the others are updated from the outbox.
+type DeleteByQueryResult struct {
+	DryRun         bool     `json:"dry_run"`
+	Matched        int64    `json:"matched"`
+	Deleted        int64    `json:"deleted"`
+	ElasticIndexed int64    `json:"elastic_indexed"`
+	IDs            []string `json:"ids"`
 }
@@ -0,0 +1,389 @@
+package order_api
+
+import (
+	"strconv"
+	"time"
+)
+
+// ElasticCompiler compiles a Query to an Elasticsearch search body
+type ElasticCompiler struct {
+	// Unique field used as the last sort key, so hits with equal sort values keep a stable order
+	TieBreaker string
+	// Nested path of the enclosing nested query, fields of this path are not wrapped again
+	nestedScope string
+}
+
+// SearchBody creates the whole search request: query, sort, _source and page
+func (c ElasticCompiler) SearchBody(query *Query) (map[string]interface{}, error) {
+	filter, err := query.Filter.Accept(c)
+	if err != nil {
+		return nil, err
+	}
+
+	searchBody := map[string]interface{}{
+		"query":            filter,
+		"sort":             c.Sort(query),
+		"size":             query.Limit,
+		"track_total_hits": true,
+	}
+
+	if len(query.Fields) > 0 {
+		searchBody["_source"] = query.Fields
+	}
+
+	if query.Offset > 0 {
+		searchBody["from"] = query.Offset
+	}
+
+	// Continue after the last hit of the previous page
+	if len(query.After) > 0 {
+		searchBody["search_after"] = query.After
+	}
+
+	return searchBody, nil
+}
+
+// Sort returns the sort clauses of the query, the tie-breaker is added for search_after
+func (c ElasticCompiler) Sort(query *Query) []map[string]interface{} {
+	orderBy := make([]map[string]interface{}, 0, len(query.Sort)+1)
+	for _, clause := range query.Sort {
+		direction := "asc"
+		if clause.Descending {
+			direction = "desc"
+		}
+
+		missing := "_last"
+		if clause.MissingFirst {
+			missing = "_first"
+		}
+
+		sortOptions := map[string]interface{}{
+			"order":   direction,
+			"missing": missing,
+		}
+		// Orders are sorted by the smallest value of their lines in ascending order and the largest in descending order
+		if path := nestedPath(clause.Field); path != "" {
+			sortOptions["nested"] = map[string]interface{}{"path": path}
+		}
+
+		orderBy = append(orderBy, map[string]interface{}{keywordField(clause.Field): sortOptions})
+	}
+
+	return append(orderBy, map[string]interface{}{c.TieBreaker: "asc"})
+}
+
+// AggregationBody creates a search request without hits: group_by fields become nested terms aggregations,
+// the date histogram is placed under them and metrics are calculated in the innermost buckets
+func (c ElasticCompiler) AggregationBody(aggregation *Aggregation) (map[string]interface{}, error) {
+	filter, err := aggregation.Filter.Accept(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count is the doc_count of the bucket, so it does not need an aggregation
+	aggs := make(map[string]interface{})
+	for i, metric := range aggregation.Metrics {
+		if metric.Type == MetricCount {
+			continue
+		}
+
+		metricAgg := map[string]interface{}{metric.Type: map[string]interface{}{"field": metric.Field}}
+		// Product metrics are calculated over the nested product lines
+		if path := nestedPath(metric.Field); path != "" {
+			metricAgg = map[string]interface{}{
+				"nested": map[string]interface{}{"path": path},
+				"aggs":   map[string]interface{}{"v": metricAgg},
+			}
+		}
+		aggs["m"+strconv.Itoa(i)] = metricAgg
+	}
+
+	if histogram := aggregation.Histogram; histogram != nil {
+		dateHistogram := map[string]interface{}{"date_histogram": map[string]interface{}{
+			"field":             histogram.Field,
+			"calendar_interval": histogram.Interval,
+			"time_zone":         histogram.TimeZone,
+			"min_doc_count":     1,
+		}}
+		if len(aggs) > 0 {
+			dateHistogram["aggs"] = aggs
+		}
+		aggs = map[string]interface{}{"h": dateHistogram}
+	}
+
+	for i := len(aggregation.GroupBy) - 1; i >= 0; i-- {
+		terms := map[string]interface{}{"terms": map[string]interface{}{
+			"field": keywordField(aggregation.GroupBy[i]),
+			"size":  MaxBuckets,
+			"order": map[string]interface{}{"_key": "asc"},
+		}}
+		if len(aggs) > 0 {
+			terms["aggs"] = aggs
+		}
+		aggs = map[string]interface{}{"g" + strconv.Itoa(i): terms}
+	}
+
+	searchBody := map[string]interface{}{
+		"query":            filter,
+		"size":             0,
+		"track_total_hits": true,
+	}
+	if len(aggs) > 0 {
+		searchBody["aggs"] = aggs
+	}
+
+	return searchBody, nil
+}
+
+// AggregateBuckets flattens the nested buckets of a search response, buckets are limited to MaxBuckets like in MongoDB
+func (c ElasticCompiler) AggregateBuckets(aggregation *Aggregation, response map[string]interface{}) []AggregateBucket {
+	var total int64
+	if hits, ok := response["hits"].(map[string]interface{}); ok {
+		if totalHits, ok := hits["total"].(map[string]interface{}); ok {
+			if value, ok := totalHits["value"].(float64); ok {
+				total = int64(value)
+			}
+		}
+	}
+
+	root, _ := response["aggregations"].(map[string]interface{})
+
+	buckets := make([]AggregateBucket, 0)
+	c.collectBuckets(aggregation, 0, root, map[string]interface{}{}, total, &buckets)
+
+	if len(buckets) > MaxBuckets {
+		buckets = buckets[:MaxBuckets]
+	}
+
+	return buckets
+}
+
+func (c ElasticCompiler) collectBuckets(aggregation *Aggregation, level int, node map[string]interface{}, key map[string]interface{}, count int64, buckets *[]AggregateBucket) {
+	levels := len(aggregation.GroupBy)
+	if aggregation.Histogram != nil {
+		levels++
+	}
+
+	// Innermost bucket keeps the metrics
+	if level == levels {
+		// MongoDB does not return a group without orders either
+		if count == 0 {
+			return
+		}
+
+		bucket := AggregateBucket{Key: make(map[string]interface{}), Count: count, Metrics: make(map[string]interface{})}
+		for field, value := range key {
+			bucket.Key[field] = value
+		}
+		for i, metric := range aggregation.Metrics {
+			if metric.Type == MetricCount {
+				bucket.Metrics[metric.Name] = count
+				continue
+			}
+			value, _ := node["m"+strconv.Itoa(i)].(map[string]interface{})
+			if nestedPath(metric.Field) != "" {
+				value, _ = value["v"].(map[string]interface{})
+			}
+			if value != nil {
+				bucket.Metrics[metric.Name] = value["value"]
+			}
+		}
+
+		*buckets = append(*buckets, bucket)
+		return
+	}
+
+	name, field := "h", ""
+	if level < len(aggregation.GroupBy) {
+		name, field = "g"+strconv.Itoa(level), aggregation.GroupBy[level]
+	} else {
+		field = aggregation.Histogram.Field
+	}
+
+	agg, _ := node[name].(map[string]interface{})
+	children, _ := agg["buckets"].([]interface{})
+	for _, child := range children {
+		childBucket, ok := child.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Date histogram keys are epoch milliseconds of the start of the interval
+		value := childBucket["key"]
+		if name == "h" {
+			if millis, ok := value.(float64); ok {
+				value = time.UnixMilli(int64(millis)).UTC().Format(time.RFC3339)
+			}
+		}
+		key[field] = value
+
+		docCount, _ := childBucket["doc_count"].(float64)
+		c.collectBuckets(aggregation, level+1, childBucket, key, int64(docCount), buckets)
+	}
+	delete(key, field)
+}
+
+func (c ElasticCompiler) CompileAnd(expr *AndExpr) (interface{}, error) {
+	clauses, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(clauses) {
+	case 0:
+		return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+	case 1:
+		return clauses[0], nil
+	}
+
+	return map[string]interface{}{"bool": map[string]interface{}{"must": clauses}}, nil
+}
+
+func (c ElasticCompiler) CompileOr(expr *OrExpr) (interface{}, error) {
+	clauses, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	// At least one clause has to match, should clauses are optional next to must clauses otherwise
+	return map[string]interface{}{"bool": map[string]interface{}{
+		"should":               clauses,
+		"minimum_should_match": 1,
+	}}, nil
+}
+
+func (c ElasticCompiler) CompileNot(expr *NotExpr) (interface{}, error) {
+	clauses, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bool": map[string]interface{}{"must_not": clauses}}, nil
+}
+
+func (c ElasticCompiler) CompileTerms(expr *TermsExpr) (interface{}, error) {
+	return c.nested(expr.Field, map[string]interface{}{"terms": map[string]interface{}{keywordField(expr.Field): expr.Values}}), nil
+}
+
+func (c ElasticCompiler) CompileRange(expr *RangeExpr) (interface{}, error) {
+	bounds := expr.Bounds()
+	for operator, value := range bounds {
+		// Elasticsearch parses dates from strings, RFC3339 keeps the same instant as MongoDB
+		if date, ok := value.(time.Time); ok {
+			bounds[operator] = date.Format(time.RFC3339Nano)
+		}
+	}
+
+	return c.nested(expr.Field, map[string]interface{}{"range": map[string]interface{}{expr.Field: bounds}}), nil
+}
+
+func (c ElasticCompiler) CompileMatch(expr *MatchExpr) (interface{}, error) {
+	switch expr.Mode {
+	case MatchModePhrase:
+		field := expr.Fields[0]
+		return c.nested(field, map[string]interface{}{"match_phrase": map[string]interface{}{textField(field): expr.Query}}), nil
+	case MatchModeMulti:
+		// A multi_match query cannot search nested and other fields together, so fields are grouped by nested path
+		paths := make(map[string][]string)
+		for _, field := range expr.Fields {
+			path := nestedPath(field)
+			paths[path] = append(paths[path], textField(field))
+		}
+
+		clauses := make([]interface{}, 0, len(paths))
+		for _, path := range sortedKeys(paths) {
+			multiMatch := map[string]interface{}{
+				"query":  expr.Query,
+				"fields": paths[path],
+			}
+			if expr.Fuzziness != "" {
+				multiMatch["fuzziness"] = expr.Fuzziness
+			}
+			clauses = append(clauses, c.nested(paths[path][0], map[string]interface{}{"multi_match": multiMatch}))
+		}
+
+		if len(clauses) == 1 {
+			return clauses[0], nil
+		}
+		return map[string]interface{}{"bool": map[string]interface{}{
+			"should":               clauses,
+			"minimum_should_match": 1,
+		}}, nil
+	default:
+		// Fuzzy mode is a match query with fuzziness, so the query text is analyzed like in the other modes
+		field := expr.Fields[0]
+		match := map[string]interface{}{"query": expr.Query}
+		if expr.Fuzziness != "" {
+			match["fuzziness"] = expr.Fuzziness
+		}
+		return c.nested(field, map[string]interface{}{"match": map[string]interface{}{textField(field): match}}), nil
+	}
+}
+
+// CompileElemMatch is a nested query, every condition of the filter has to match the same nested object.
+// Named expressions return the matching objects as inner hits.
+func (c ElasticCompiler) CompileElemMatch(expr *ElemMatchExpr) (interface{}, error) {
+	query, err := expr.Filter.Accept(ElasticCompiler{TieBreaker: c.TieBreaker, nestedScope: expr.Path})
+	if err != nil {
+		return nil, err
+	}
+
+	nested := map[string]interface{}{
+		"path":  expr.Path,
+		"query": query,
+	}
+	if expr.Name != "" {
+		// Inner hits are limited by index.max_inner_result_window (100 by default)
+		nested["inner_hits"] = map[string]interface{}{"name": expr.Name, "size": 100}
+	}
+
+	return map[string]interface{}{"nested": nested}, nil
+}
+
+func (c ElasticCompiler) CompileExists(expr *ExistsExpr) (interface{}, error) {
+	return c.nested(expr.Field, map[string]interface{}{"exists": map[string]interface{}{"field": expr.Field}}), nil
+}
+
+// nested wraps the query of a field in a nested object, so the query matches orders with a matching line
+func (c ElasticCompiler) nested(field string, query map[string]interface{}) map[string]interface{} {
+	path := nestedPath(field)
+	if path == "" || path == c.nestedScope {
+		return query
+	}
+
+	return map[string]interface{}{"nested": map[string]interface{}{
+		"path":  path,
+		"query": query,
+	}}
+}
+
+// textField returns the text sub-field of a keyword field for full-text queries
+func textField(field string) string {
+	if text, ok := elasticTextFields[field]; ok {
+		return text
+	}
+
+	return field
+}
+
+// keywordField returns the keyword sub-field of a text field for exact filters, sorting and grouping
+func keywordField(field string) string {
+	if keyword, ok := elasticKeywordFields[field]; ok {
+		return keyword
+	}
+
+	return field
+}
+
+func (c ElasticCompiler) compileChildren(children []Expr) ([]interface{}, error) {
+	clauses := make([]interface{}, 0, len(children))
+	for _, child := range children {
+		clause, err := child.Accept(c)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	return clauses, nil
+}
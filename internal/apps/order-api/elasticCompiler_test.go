@@ -0,0 +1,213 @@
+package order_api
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+// normalizeJSON decodes json to maps and slices, so documents can be compared without the order of map keys
+func normalizeJSON(t *testing.T, data []byte) interface{} {
+	t.Helper()
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatalf("%s is not valid json: %v", data, err)
+	}
+
+	return value
+}
+
+func TestRequestsAreCompiledToBothBackends(t *testing.T) {
+	// MongoDB filters are written as relaxed extended JSON, so dates and regular expressions keep their types
+	tests := []struct {
+		name    string
+		body    string
+		mongo   string
+		elastic string
+	}{
+		{
+			name:    "empty filter",
+			body:    `{"include_deleted": true}`,
+			mongo:   `{}`,
+			elastic: `{"match_all": {}}`,
+		},
+		{
+			name:    "deleted orders are left out",
+			body:    `{}`,
+			mongo:   `{"$nor": [{"deletedAt": {"$exists": true, "$ne": null}}]}`,
+			elastic: `{"bool": {"must_not": [{"exists": {"field": "deletedAt"}}]}}`,
+		},
+		{
+			name: "exact filter and date range",
+			body: `{"include_deleted": true, "exact_filters": {"city": ["Ankara"]}, "range": {"createdAt": {"gte": "2023-05-01", "lt": "2023-06-01"}}}`,
+			mongo: `{"$and": [
+				{"city": {"$in": ["Ankara"]}},
+				{"createdAt": {"$gte": {"$date": "2023-05-01T00:00:00Z"}, "$lt": {"$date": "2023-06-01T00:00:00Z"}}}
+			]}`,
+			elastic: `{"bool": {"must": [
+				{"terms": {"city": ["Ankara"]}},
+				{"range": {"createdAt": {"gte": "2023-05-01T00:00:00Z", "lt": "2023-06-01T00:00:00Z"}}}
+			]}}`,
+		},
+		{
+			name: "or and not groups",
+			body: `{"include_deleted": true, "query": {"or": [{"exact_filters": {"status": ["created"]}}, {"not": [{"exact_filters": {"city": ["Izmir"]}}]}]}}`,
+			mongo: `{"$or": [
+				{"status": {"$in": ["created"]}},
+				{"$nor": [{"city": {"$in": ["Izmir"]}}]}
+			]}`,
+			elastic: `{"bool": {"minimum_should_match": 1, "should": [
+				{"terms": {"status": ["created"]}},
+				{"bool": {"must_not": [{"terms": {"city": ["Izmir"]}}]}}
+			]}}`,
+		},
+		{
+			name:    "id",
+			body:    `{"include_deleted": true, "exact_filters": {"id": ["order-1"]}}`,
+			mongo:   `{"_id": {"$in": ["order-1"]}}`,
+			elastic: `{"terms": {"id": ["order-1"]}}`,
+		},
+		{
+			name:    "exact filter on the keyword sub-field of a text field",
+			body:    `{"include_deleted": true, "exact_filters": {"addressDetail": ["Kizilay 1"]}}`,
+			mongo:   `{"addressDetail": {"$in": ["Kizilay 1"]}}`,
+			elastic: `{"terms": {"addressDetail.keyword": ["Kizilay 1"]}}`,
+		},
+		{
+			name:    "phrase on the text sub-field",
+			body:    `{"include_deleted": true, "search": [{"fields": ["city"], "query": "New York", "mode": "match_phrase"}]}`,
+			mongo:   `{"city": {"$regularExpression": {"pattern": "(?:^|[^\\p{L}\\p{N}])New[^\\p{L}\\p{N}]+York(?:$|[^\\p{L}\\p{N}])", "options": "i"}}}`,
+			elastic: `{"match_phrase": {"city.text": "New York"}}`,
+		},
+		{
+			name: "multi_match over a nested field",
+			body: `{"include_deleted": true, "search": [{"fields": ["city", "product.name"], "query": "pen", "mode": "multi_match"}]}`,
+			mongo: `{"$or": [
+				{"city": {"$regularExpression": {"pattern": "(?:^|[^\\p{L}\\p{N}])(?:pen)(?:$|[^\\p{L}\\p{N}])", "options": "i"}}},
+				{"product.name": {"$regularExpression": {"pattern": "(?:^|[^\\p{L}\\p{N}])(?:pen)(?:$|[^\\p{L}\\p{N}])", "options": "i"}}}
+			]}`,
+			elastic: `{"bool": {"minimum_should_match": 1, "should": [
+				{"multi_match": {"fields": ["city.text"], "query": "pen"}},
+				{"nested": {"path": "product", "query": {"multi_match": {"fields": ["product.name.text"], "query": "pen"}}}}
+			]}}`,
+		},
+		{
+			name:    "range on product lines needs one line within both bounds",
+			body:    `{"include_deleted": true, "range": {"product.price": {"gte": 10, "lte": 20}}}`,
+			mongo:   `{"product": {"$elemMatch": {"price": {"$gte": 10.0, "$lte": 20.0}}}}`,
+			elastic: `{"nested": {"path": "product", "query": {"range": {"product.price": {"gte": 10, "lte": 20}}}}}`,
+		},
+		{
+			name:    "negated range on product lines",
+			body:    `{"include_deleted": true, "query": {"not": [{"range": {"product.quantity": {"gt": 1, "lt": 5}}}]}}`,
+			mongo:   `{"$nor": [{"product": {"$elemMatch": {"quantity": {"$gt": 1.0, "$lt": 5.0}}}}]}`,
+			elastic: `{"bool": {"must_not": [{"nested": {"path": "product", "query": {"range": {"product.quantity": {"gt": 1, "lt": 5}}}}}]}}`,
+		},
+		{
+			name:    "match on a number",
+			body:    `{"include_deleted": true, "match": {"total": "100"}}`,
+			mongo:   `{"total": 100.0}`,
+			elastic: `{"match": {"total": {"query": 100}}}`,
+		},
+		{
+			name: "product line filter matches one line",
+			body: `{"include_deleted": true, "exact_filters": {"product.name": ["Pen"]}, "product_lines": [{"exact_filters": {"name": ["Pen"]}, "range": {"quantity": {"gte": 2}}}]}`,
+			mongo: `{"$and": [
+				{"product.name": {"$in": ["Pen"]}},
+				{"product": {"$elemMatch": {"$and": [{"name": {"$in": ["Pen"]}}, {"quantity": {"$gte": 2.0}}]}}}
+			]}`,
+			elastic: `{"bool": {"must": [
+				{"nested": {"path": "product", "query": {"terms": {"product.name": ["Pen"]}}}},
+				{"nested": {"path": "product", "query": {"bool": {"must": [
+					{"terms": {"product.name": ["Pen"]}},
+					{"range": {"product.quantity": {"gte": 2}}}
+				]}}}}
+			]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parseRequest(t, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mongoCompiler MongoCompiler
+			filter, err := mongoCompiler.Filter(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mongoJSON, err := bson.MarshalExtJSON(filter, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := normalizeJSON(t, []byte(test.mongo)), normalizeJSON(t, mongoJSON); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected MongoDB filter %s, got %s", test.mongo, mongoJSON)
+			}
+
+			elasticQuery, err := query.Filter.Accept(ElasticCompiler{TieBreaker: elasticTieBreaker})
+			if err != nil {
+				t.Fatal(err)
+			}
+			elasticJSON, err := json.Marshal(elasticQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := normalizeJSON(t, []byte(test.elastic)), normalizeJSON(t, elasticJSON); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected Elasticsearch query %s, got %s", test.elastic, elasticJSON)
+			}
+		})
+	}
+}
+
+func TestElasticSearchBody(t *testing.T) {
+	cursor, err := encodeCursor([]interface{}{"Ankara", 20.0, "Kizilay", "order-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := ParseOrderGetRequest(OrderGetRequest{
+		OrderFilter: OrderFilter{IncludeDeleted: true},
+		Fields:      []string{"city", "total"},
+		Sort: []SortRequest{
+			{Field: "city", Missing: "first"},
+			{Field: "product.price", Direction: "desc"},
+			{Field: "addressDetail"},
+		},
+		Limit:  10,
+		Cursor: cursor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	searchBody, err := ElasticCompiler{TieBreaker: elasticTieBreaker}.SearchBody(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(searchBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every sort key is kept in the given order and the tie-breaker is the last one
+	expected := `{
+		"query": {"match_all": {}},
+		"sort": [
+			{"city": {"order": "asc", "missing": "_first"}},
+			{"product.price": {"order": "desc", "missing": "_last", "nested": {"path": "product"}}},
+			{"addressDetail.keyword": {"order": "asc", "missing": "_last"}},
+			{"id": "asc"}
+		],
+		"size": 10,
+		"track_total_hits": true,
+		"_source": ["city", "total"],
+		"search_after": ["Ankara", 20, "Kizilay", "order-1"]
+	}`
+	if !reflect.DeepEqual(normalizeJSON(t, []byte(expected)), normalizeJSON(t, data)) {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
@@ -0,0 +1,518 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/pkg"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/labstack/gommon/log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Versioned index names end with _v and a number, like generic_endpoint_v01
+var indexVersionPattern = regexp.MustCompile(`^(.+)_v(\d+)$`)
+
+// Nested fields are indexed as separate documents, so conditions on one product line do not match across lines
+var elasticNestedPaths = []string{"product"}
+
+// Keyword fields with a text sub-field, full-text queries use the sub-field. The text is lowercased and split
+// into words like the case-insensitive word match of MongoDB.
+var elasticTextFields = map[string]string{
+	"userId":       "userId.text",
+	"status":       "status.text",
+	"city":         "city.text",
+	"product.name": "product.name.text",
+}
+
+// Text fields with a keyword sub-field, exact filters, sorting and grouping use the sub-field. Text fields
+// have no doc values, so they cannot be sorted or grouped and their terms are the analyzed words.
+var elasticKeywordFields = map[string]string{
+	"addressDetail": "addressDetail.keyword",
+}
+
+// Orders are indexed with their version as an external version, so a retried or delayed write of an older
+// version is rejected. An equal version is written again, so the consistency checker can repair a stale order.
+const elasticVersionType = "external_gte"
+
+// Fields whose type is checked on the indices of the alias, indices created by dynamic mapping have other types.
+// Sub-fields are checked by their full name, full-text queries find nothing on an index without them.
+var verifiedFieldTypes = map[string]string{
+	"id":          "keyword",
+	"userId":      "keyword",
+	"userId.text": "text",
+	"status":      "keyword",
+	"status.text": "text",
+	"city":        "keyword",
+	"city.text":   "text",
+	"product":     "nested",
+	// Exact filters, sorting and grouping by addressDetail use the keyword sub-field
+	"addressDetail.keyword": "keyword",
+}
+
+// OrderIndexSettings returns the analysis settings of the order index
+func OrderIndexSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"analysis": map[string]interface{}{
+			"filter": map[string]interface{}{
+				// Dotted and dotless i are lowercased like in Turkish, "İstanbul" becomes "istanbul"
+				"turkish_lowercase": map[string]interface{}{"type": "lowercase", "language": "turkish"},
+				"turkish_stop":      map[string]interface{}{"type": "stop", "stopwords": "_turkish_"},
+				"turkish_stemmer":   map[string]interface{}{"type": "stemmer", "language": "turkish"},
+			},
+			"analyzer": map[string]interface{}{
+				// Suffixes after an apostrophe are removed ("Kadıköy'de" is "Kadıköy") and accents are folded
+				// after stemming, so addresses typed without Turkish characters match too
+				"address_turkish": map[string]interface{}{
+					"type":      "custom",
+					"tokenizer": "standard",
+					"filter":    []string{"apostrophe", "turkish_lowercase", "turkish_stop", "turkish_stemmer", "asciifolding"},
+				},
+			},
+		},
+	}
+}
+
+// OrderIndexMappings returns the mappings of the order index, unknown fields are still mapped dynamically
+func OrderIndexMappings() map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword"}
+	keywordWithText := map[string]interface{}{
+		"type": "keyword",
+		"fields": map[string]interface{}{
+			"text": map[string]interface{}{"type": "text"},
+		},
+	}
+	date := map[string]interface{}{"type": "date"}
+
+	return map[string]interface{}{
+		"properties": map[string]interface{}{
+			"id":     keyword,
+			"userId": keywordWithText,
+			"status": keywordWithText,
+			"city":   keywordWithText,
+			"addressDetail": map[string]interface{}{
+				"type":     "text",
+				"analyzer": "address_turkish",
+				"fields": map[string]interface{}{
+					// Longer values are not indexed as keywords, validation allows 500 characters
+					"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 500},
+				},
+			},
+			"product": map[string]interface{}{
+				"type": "nested",
+				"properties": map[string]interface{}{
+					"name":     keywordWithText,
+					"quantity": map[string]interface{}{"type": "long"},
+					"price":    map[string]interface{}{"type": "double"},
+				},
+			},
+			"total":         map[string]interface{}{"type": "double"},
+			"createdAt":     date,
+			"updatedAt":     date,
+			"deletedAt":     date,
+			"version":       map[string]interface{}{"type": "long"},
+			"statusHistory": map[string]interface{}{"type": "object"},
+		},
+		// Every status in statusHistory is a date
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"status_history": map[string]interface{}{
+					"path_match": "statusHistory.*",
+					"mapping":    date,
+				},
+			},
+		},
+	}
+}
+
+// nestedPath returns the nested object of the field, or an empty string if the field is not in a nested object
+func nestedPath(field string) string {
+	for _, path := range elasticNestedPaths {
+		if strings.HasPrefix(field, path+".") {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// PutOrderTemplate installs the index template of the versioned order indices, so every index created
+// for the alias (by reindex or by hand) gets the same settings and mappings
+func (e *ElasticService) PutOrderTemplate() error {
+	alias := e.Config.Elasticsearch.AliasName["Order"]
+
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{alias + "_v*"},
+		"template": map[string]interface{}{
+			"settings": OrderIndexSettings(),
+			"mappings": OrderIndexMappings(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := e.ElasticClient.Indices.PutIndexTemplate(alias, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return responseError(res)
+}
+
+// VerifyOrderIndex checks the mappings of the indices of the alias, an index which was created by dynamic
+// mapping must be replaced by the reindex command
+func (e *ElasticService) VerifyOrderIndex() error {
+	alias := e.Config.Elasticsearch.AliasName["Order"]
+
+	res, err := e.ElasticClient.Indices.GetMapping(e.ElasticClient.Indices.GetMapping.WithIndex(alias))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := responseError(res); err != nil {
+		return err
+	}
+
+	type property struct {
+		Type   string              `json:"type"`
+		Fields map[string]property `json:"fields"`
+	}
+	var r map[string]struct {
+		Mappings struct {
+			Properties map[string]property `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+
+	for _, index := range sortedKeys(r) {
+		properties := r[index].Mappings.Properties
+		for _, field := range sortedKeys(verifiedFieldTypes) {
+			// Fields which are not indexed yet are mapped by the template when the first order is indexed
+			name, subField, isSubField := strings.Cut(field, ".")
+			mapping, ok := properties[name]
+			if !ok {
+				continue
+			}
+			if isSubField {
+				mapping = mapping.Fields[subField]
+			}
+
+			fieldType := mapping.Type
+			if fieldType == "" && isSubField {
+				fieldType = "missing"
+			} else if fieldType == "" {
+				fieldType = "object"
+			}
+			if fieldType != verifiedFieldTypes[field] {
+				return fmt.Errorf("field %s of index %s is %s instead of %s, the orders must be reindexed", field, index, fieldType, verifiedFieldTypes[field])
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnsureOrderIndex installs the index template and creates the first order index and its alias if the alias
+// does not exist yet. An index created before the alias was used gets the alias, so its documents are kept.
+func (e *ElasticService) EnsureOrderIndex() error {
+	alias := e.Config.Elasticsearch.AliasName["Order"]
+	index := e.Config.Elasticsearch.IndexName["Order"]
+
+	if err := e.PutOrderTemplate(); err != nil {
+		return err
+	}
+
+	indices, err := e.GetAliasIndices(alias)
+	if err != nil {
+		return err
+	}
+	if len(indices) > 0 {
+		return nil
+	}
+
+	exists, err := e.IndexExists(index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := e.CreateOrderIndex(index); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Alias {%s} is added to index {%s}", alias, index)
+	return e.SwapAlias(alias, index, nil)
+}
+
+// NextIndexName returns the name of the next version of the index, v01 is followed by v02
+func NextIndexName(index string) (string, error) {
+	matches := indexVersionPattern.FindStringSubmatch(index)
+	if matches == nil {
+		return "", fmt.Errorf("index %q does not end with a version like _v01", index)
+	}
+
+	version, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s_v%02d", matches[1], version+1), nil
+}
+
+// CreateOrderIndex creates the index with the order mappings
+func (e *ElasticService) CreateOrderIndex(index string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"settings": OrderIndexSettings(),
+		"mappings": OrderIndexMappings(),
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := e.ElasticClient.Indices.Create(index, e.ElasticClient.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return responseError(res)
+}
+
+func (e *ElasticService) IndexExists(index string) (bool, error) {
+	res, err := e.ElasticClient.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return true, responseError(res)
+}
+
+func (e *ElasticService) DeleteIndex(index string) error {
+	res, err := e.ElasticClient.Indices.Delete([]string{index})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return responseError(res)
+}
+
+// GetAliasIndices returns the indices of the alias, the list is empty if the alias does not exist
+func (e *ElasticService) GetAliasIndices(alias string) ([]string, error) {
+	res, err := e.ElasticClient.Indices.GetAlias(e.ElasticClient.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if err := responseError(res); err != nil {
+		return nil, err
+	}
+
+	var r map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	indices := sortedKeys(r)
+	return indices, nil
+}
+
+// SwapAlias moves the alias from the old indices to the index in one atomic request
+func (e *ElasticService) SwapAlias(alias string, index string, oldIndices []string) error {
+	actions := make([]map[string]interface{}, 0, len(oldIndices)+1)
+	for _, oldIndex := range oldIndices {
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": oldIndex, "alias": alias}})
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": index, "alias": alias, "is_write_index": true}})
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := e.ElasticClient.Indices.UpdateAliases(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return responseError(res)
+}
+
+// RefreshIndex makes the indexed documents searchable, so they can be counted
+func (e *ElasticService) RefreshIndex(index string) error {
+	res, err := e.ElasticClient.Indices.Refresh(e.ElasticClient.Indices.Refresh.WithIndex(index))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return responseError(res)
+}
+
+// CountDocuments returns the number of documents in the index or alias
+func (e *ElasticService) CountDocuments(index string) (int64, error) {
+	res, err := e.ElasticClient.Count(e.ElasticClient.Count.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if err := responseError(res); err != nil {
+		return 0, err
+	}
+
+	var r struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+
+	return r.Count, nil
+}
+
+// BulkIndexOrders indexes the orders to the index with one bulk request, an error is returned if any order fails
+func (e *ElasticService) BulkIndexOrders(index string, orders []models.Order) error {
+	failed, err := e.bulkIndex(index, orders, "false")
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if reason, ok := failed[order.ID]; ok {
+			return fmt.Errorf("order %s could not be indexed: %s", order.ID, reason)
+		}
+	}
+
+	return nil
+}
+
+// BulkSaveOrders indexes the orders through the order alias and waits until they are searchable,
+// the reasons of failed orders are returned by order id
+func (e *ElasticService) BulkSaveOrders(orders []models.Order) (map[string]string, error) {
+	return e.bulkIndex(e.Config.Elasticsearch.AliasName["Order"], orders, "wait_for")
+}
+
+func (e *ElasticService) bulkIndex(index string, orders []models.Order, refresh string) (map[string]string, error) {
+	failed := make(map[string]string)
+	if len(orders) == 0 {
+		return failed, nil
+	}
+
+	var buf bytes.Buffer
+	for _, order := range orders {
+		meta, err := json.Marshal(map[string]interface{}{"index": map[string]interface{}{
+			"_index":       index,
+			"_id":          order.ID,
+			"version":      order.Version,
+			"version_type": elasticVersionType,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(meta)
+		buf.WriteByte('\n')
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	req := esapi.BulkRequest{Body: &buf, Refresh: refresh}
+	res, err := req.Do(context.Background(), e.ElasticClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := responseError(res); err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string                 `json:"_id"`
+			Status int                    `json:"status"`
+			Error  map[string]interface{} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	if !r.Errors {
+		return failed, nil
+	}
+
+	ordersByID := make(map[string]models.Order, len(orders))
+	for _, order := range orders {
+		ordersByID[order.ID] = order
+	}
+
+	for _, item := range r.Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+
+			// Conflicts are skipped only if a newer version of the order is already indexed
+			if result.Status == http.StatusConflict {
+				if err := e.checkVersionConflict(index, ordersByID[result.ID]); err != nil {
+					failed[result.ID] = err.Error()
+				}
+				continue
+			}
+
+			failed[result.ID] = fmt.Sprintf("%v", result.Error["reason"])
+		}
+	}
+
+	return failed, nil
+}
+
+// responseError converts an error response of Elasticsearch to a *pkg.ElasticsearchError, nil is returned for other responses
+func responseError(res *esapi.Response) error {
+	if !res.IsError() {
+		return nil
+	}
+
+	var e map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return &pkg.ElasticsearchError{StatusCode: res.StatusCode, Reason: err.Error()}
+	}
+
+	if cause, ok := e["error"].(map[string]interface{}); ok {
+		return &pkg.ElasticsearchError{
+			StatusCode: res.StatusCode,
+			Type:       fmt.Sprint(cause["type"]),
+			Reason:     fmt.Sprint(cause["reason"]),
+		}
+	}
+
+	return &pkg.ElasticsearchError{StatusCode: res.StatusCode, Reason: fmt.Sprint(e["error"])}
+}
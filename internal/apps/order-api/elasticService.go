@@ -3,6 +3,7 @@ package order_api
 import (
 	"GenericEndpoint/internal/configs"
 	"GenericEndpoint/internal/models"
+	"GenericEndpoint/pkg"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,8 +11,13 @@ import (
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/labstack/gommon/log"
+	"net/http"
+	"time"
 )
 
+// Unique field used as the last sort key, so hits with equal sort values keep a stable order
+const elasticTieBreaker = "id"
+
 type ElasticService struct {
 	Config        *configs.Config
 	ElasticClient *elasticsearch.Client
@@ -28,7 +34,7 @@ func NewElasticService(config *configs.Config) *ElasticService {
 	elasticClient, err := elasticsearch.NewClient(cfg)
 
 	if err != nil {
-		log.Errorf("Error creating the client: ", err)
+		log.Errorf("Error creating the client: %s", err)
 	}
 
 	elasticService := &ElasticService{Config: config, ElasticClient: elasticClient}
@@ -44,43 +50,90 @@ func (e *ElasticService) SaveOrderToElasticsearch(order models.Order) error {
 	}
 
 	// Set up the request object.
+	version := int(order.Version)
 	req := esapi.IndexRequest{
-		Index:      e.Config.Elasticsearch.IndexName["Order"],
-		DocumentID: order.ID,
-		Body:       bytes.NewReader(data),
-		Refresh:    "true",
+		Index:       e.Config.Elasticsearch.AliasName["Order"],
+		DocumentID:  order.ID,
+		Body:        bytes.NewReader(data),
+		Refresh:     "true",
+		Version:     &version,
+		VersionType: elasticVersionType,
 	}
 
 	// Perform the request with the client.
 	res, err := req.Do(context.Background(), e.ElasticClient)
 	if err != nil {
 		log.Errorf("Error getting response: %s", err)
+		return &pkg.ElasticsearchError{Reason: err.Error()}
+	}
+	defer res.Body.Close()
+
+	// The indexed order can be newer, or it can be indexed before external versioning with a higher _version
+	if res.StatusCode == http.StatusConflict {
+		return e.checkVersionConflict(req.Index, order)
+	}
+
+	if err := responseError(res); err != nil {
+		log.Errorf("Error response of Elasticsearch: %v", err)
 		return err
 	}
+
+	return nil
+}
+
+// checkVersionConflict returns nil if the indexed order has the same or a newer version than the order, so the
+// write can be skipped. Documents indexed before external versioning have an internal _version which can be
+// higher than the version of the order, they are returned as a conflict because the index must be reindexed.
+func (e *ElasticService) checkVersionConflict(index string, order models.Order) error {
+	req := esapi.GetRequest{
+		Index:          index,
+		DocumentID:     order.ID,
+		SourceIncludes: []string{"version"},
+	}
+
+	res, err := req.Do(context.Background(), e.ElasticClient)
+	if err != nil {
+		return &pkg.ElasticsearchError{Reason: err.Error()}
+	}
 	defer res.Body.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
-			log.Errorf("Error parsing the response body: %s", err)
+	var indexed struct {
+		Version int64 `json:"_version"`
+		Found   bool  `json:"found"`
+		Source  struct {
+			Version *int64 `json:"version"`
+		} `json:"_source"`
+	}
+	if res.StatusCode != http.StatusNotFound {
+		if err := responseError(res); err != nil {
+			return err
+		}
+		if err := json.NewDecoder(res.Body).Decode(&indexed); err != nil {
 			return err
-		} else {
-			// Print the error information.
-			log.Errorf("[%s] %s: %s",
-				res.Status(),
-				e["error"].(map[string]interface{})["type"],
-				e["error"].(map[string]interface{})["reason"],
-			)
 		}
 	}
 
-	return nil
+	if indexed.Found && indexed.Source.Version != nil && *indexed.Source.Version >= order.Version {
+		log.Infof("Version %d of order {%v} is older than the indexed order, it is skipped", order.Version, order.ID)
+		return nil
+	}
+
+	conflict := &pkg.ElasticsearchError{
+		StatusCode: http.StatusConflict,
+		Type:       "version_conflict_engine_exception",
+		Reason: fmt.Sprintf("version %d of order %s is lower than _version %d of the indexed document, which is "+
+			"not a newer order (indexed before external versioning), the index must be reindexed",
+			order.Version, order.ID, indexed.Version),
+	}
+	log.Errorf("Error response of Elasticsearch: %v", conflict)
+
+	return conflict
 }
 
 func (e *ElasticService) DeleteOrderFromElasticsearch(orderID string) error {
 	// Create request object
 	req := esapi.DeleteRequest{
-		Index:      e.Config.Elasticsearch.IndexName["Order"],
+		Index:      e.Config.Elasticsearch.AliasName["Order"],
 		DocumentID: orderID,
 		Refresh:    "true",
 	}
@@ -88,135 +141,179 @@ func (e *ElasticService) DeleteOrderFromElasticsearch(orderID string) error {
 	// Execute the request
 	res, err := req.Do(context.Background(), e.ElasticClient)
 	if err != nil {
-		return err
+		return &pkg.ElasticsearchError{Reason: err.Error()}
 	}
 	defer res.Body.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
-			log.Errorf("Error parsing the response body: %s", err)
-			return err
-		} else {
-			// Print the error information.
-			log.Errorf("[%s] %s: %s",
-				res.Status(),
-				e["error"].(map[string]interface{})["type"],
-				e["error"].(map[string]interface{})["reason"],
-			)
-		}
+	// Document is already deleted
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if err := responseError(res); err != nil {
+		log.Errorf("Error response of Elasticsearch: %v", err)
+		return err
 	}
 
 	return nil
 }
 
-func (e *ElasticService) GetFromElasticsearch(req OrderGetRequest) ([]interface{}, error) {
+func (e *ElasticService) GetFromElasticsearch(req OrderGetRequest) ([]interface{}, int64, string, error) {
 
-	searchBody := make(map[string]interface{})
-	query := make(map[string]interface{})
+	// Parse and validate the request (exact filter, range, query groups, match, sort, field and page)
+	query, err := ParseOrderGetRequest(req)
+	if err != nil {
+		return nil, 0, "", err
+	}
 
-	// Creating query for exact filters
-	if len(req.ExactFilters) > 0 {
-		boolQuery := make(map[string]interface{})
-		mustClauses := make([]map[string]interface{}, 0)
+	searchBody, err := ElasticCompiler{TieBreaker: elasticTieBreaker}.SearchBody(query)
+	if err != nil {
+		return nil, 0, "", err
+	}
 
-		for field, values := range req.ExactFilters {
-			if len(values) > 0 {
-				mustClause := make(map[string]interface{})
-				mustClause["terms"] = map[string]interface{}{
-					field: values,
-				}
-				mustClauses = append(mustClauses, mustClause)
-			}
+	r, err := e.search(searchBody)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	var orders []interface{}
+
+	// A response without hits or a hit without _source is an error of Elasticsearch, not an empty page
+	hitsBody, ok := r["hits"].(map[string]interface{})
+	if !ok {
+		log.Errorf("Hits not found in the search response")
+		return nil, 0, "", &pkg.ElasticsearchError{StatusCode: http.StatusOK, Reason: "search response has no hits"}
+	}
+	hits, _ := hitsBody["hits"].([]interface{})
+	for _, hit := range hits {
+
+		// Casting with type assertion
+		hitMap, _ := hit.(map[string]interface{})
+		source, ok := hitMap["_source"]
+		if !ok {
+			log.Errorf("Source not found in the hit %v", hitMap["_id"])
+			return nil, 0, "", &pkg.ElasticsearchError{StatusCode: http.StatusOK, Reason: "hit has no _source"}
 		}
 
-		boolQuery["must"] = mustClauses
-		query["bool"] = boolQuery
-	}
-
-	// TODO: Match çalışmıyor kontrol edilecek
-	// Creating query for match
-	if len(req.Match) > 0 {
-		for field, value := range req.Match {
-			if query[field] != nil {
-				// Adding a match query to exist bool query
-				boolQuery := query[field].(map[string]interface{})
-				boolQuery["match"] = map[string]interface{}{
-					field: value,
-				}
-				query[field] = boolQuery
-			} else {
-				// Creating new bool query
-				boolQuery := make(map[string]interface{})
-				boolQuery["match"] = map[string]interface{}{
-					field: value,
-				}
-				query[field] = boolQuery
+		// Only the product lines matching the product line filters are returned
+		if innerHits, ok := hitMap["inner_hits"].(map[string]interface{}); ok && len(query.Lines) > 0 {
+			if sourceMap, ok := source.(map[string]interface{}); ok {
+				sourceMap["product"] = matchingLinesFromHits(innerHits)
 			}
 		}
+		orders = append(orders, source)
 	}
 
-	searchBody["query"] = query
+	var total int64
+	if totalHits, ok := hitsBody["total"].(map[string]interface{}); ok {
+		if value, ok := totalHits["value"].(float64); ok {
+			total = int64(value)
+		}
+	}
 
-	if len(req.Sort) > 0 {
-		for field, value := range req.Sort {
-			if value == -1 {
-				searchBody["sort"] = map[string]interface{}{
-					field: "desc",
-				}
-			} else if value == 1 {
-				searchBody["sort"] = map[string]interface{}{
-					field: "asc",
-				}
+	var nextCursor string
+	if len(hits) == query.Limit {
+		if values, ok := hits[len(hits)-1].(map[string]interface{})["sort"].([]interface{}); ok {
+			nextCursor, err = encodeCursor(values)
+			if err != nil {
+				return nil, 0, "", err
 			}
 		}
 	}
 
-	if len(req.Fields) > 0 {
-		searchBody["_source"] = req.Fields
+	return orders, total, nextCursor, nil
+}
+
+// AggregateFromElasticsearch groups the filtered orders and calculates the metrics of every group
+func (e *ElasticService) AggregateFromElasticsearch(req OrderAggregateRequest) ([]AggregateBucket, error) {
+	aggregation, err := ParseOrderAggregateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var compiler ElasticCompiler
+
+	searchBody, err := compiler.AggregationBody(aggregation)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := e.search(searchBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return compiler.AggregateBuckets(aggregation, r), nil
+}
+
+// GetUpdateTimesAfterID lists the id and updatedAt of indexed orders in id order, the list starts after afterID
+func (e *ElasticService) GetUpdateTimesAfterID(afterID string, limit int) ([]models.Order, error) {
+	searchBody := map[string]interface{}{
+		"query":   map[string]interface{}{"match_all": map[string]interface{}{}},
+		"_source": []string{"updatedAt"},
+		"sort":    []interface{}{map[string]interface{}{elasticTieBreaker: "asc"}},
+		"size":    limit,
+	}
+	if afterID != "" {
+		searchBody["search_after"] = []interface{}{afterID}
+	}
+
+	r, err := e.search(searchBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+
+	hitsBody, _ := r["hits"].(map[string]interface{})
+	hits, _ := hitsBody["hits"].([]interface{})
+	for _, hit := range hits {
+		hitMap, _ := hit.(map[string]interface{})
+		order := models.Order{}
+		order.ID, _ = hitMap["_id"].(string)
+
+		if source, ok := hitMap["_source"].(map[string]interface{}); ok {
+			if updatedAt, ok := source["updatedAt"].(string); ok {
+				// A value which cannot be parsed is left as zero time, so the order is reported as stale
+				order.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+			}
+		}
+
+		orders = append(orders, order)
 	}
 
+	return orders, nil
+}
+
+// search runs the search body on the order index and decodes the response
+func (e *ElasticService) search(searchBody map[string]interface{}) (map[string]interface{}, error) {
 	buf := new(bytes.Buffer)
 	if err := json.NewEncoder(buf).Encode(searchBody); err != nil {
-		fmt.Println("Error encoding the query: ", err)
+		log.Errorf("Error encoding the query: %s", err)
 		return nil, err
 	}
 
 	res, err := e.ElasticClient.Search(
-		e.ElasticClient.Search.WithIndex(e.Config.Elasticsearch.IndexName["Order"]),
+		e.ElasticClient.Search.WithIndex(e.Config.Elasticsearch.AliasName["Order"]),
 		e.ElasticClient.Search.WithBody(buf),
 	)
 	if err != nil {
-		fmt.Println("Error executing the search: ", err)
-		return nil, err
+		log.Errorf("Error executing the search: %s", err)
+		return nil, &pkg.ElasticsearchError{Reason: err.Error()}
 	}
 
 	defer res.Body.Close()
 
-	if res.IsError() {
-		fmt.Println("Error executing the decode: ", err)
+	if err := responseError(res); err != nil {
+		log.Errorf("Error response of the search: %s", err)
 		return nil, err
 	}
 
 	var r map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		fmt.Println("Error executing the decode: ", err)
-		return nil, err
+		log.Errorf("Error decoding the search response: %s", err)
+		return nil, &pkg.ElasticsearchError{StatusCode: res.StatusCode, Reason: err.Error()}
 	}
 
-	var orders []interface{}
-
-	hits := r["hits"].(map[string]interface{})["hits"].([]interface{})
-	for _, hit := range hits {
-
-		// Casting with type assertion
-		source, ok := hit.(map[string]interface{})["_source"]
-		if !ok {
-			fmt.Println("Source not found in the hit", err)
-			return nil, err
-		}
-		orders = append(orders, source)
-	}
-
-	return orders, nil
+	return r, nil
 }
@@ -0,0 +1,198 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/pkg"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldType is the type which the values of a field are converted to
+type fieldType int
+
+const (
+	fieldKeyword fieldType = iota
+	fieldInteger
+	fieldNumber
+	fieldDate
+	// Objects and arrays of objects can be selected and tested for existence, their fields are filtered
+	fieldObject
+)
+
+// orderFields lists every field path of an order by its json name, keys of maps are matched by a * segment.
+// It is derived from models.Order, so a new field of the model can be queried without a change here.
+// mongoFieldNames has the bson names of the paths which are stored under another name, like id as _id.
+var orderFields, mongoFieldNames = deriveOrderFields()
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func deriveOrderFields() (map[string]fieldType, map[string]string) {
+	fields := make(map[string]fieldType)
+	bsonNames := make(map[string]string)
+	deriveFields(reflect.TypeOf(models.Order{}), "", "", fields, bsonNames)
+
+	return fields, bsonNames
+}
+
+func deriveFields(t reflect.Type, prefix string, bsonPrefix string, fields map[string]fieldType, bsonNames map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		bsonName, _, _ := strings.Cut(field.Tag.Get("bson"), ",")
+		if bsonName == "" {
+			bsonName = name
+		}
+
+		if prefix+name != bsonPrefix+bsonName {
+			bsonNames[prefix+name] = bsonPrefix + bsonName
+		}
+		deriveField(field.Type, prefix+name, bsonPrefix+bsonName, fields, bsonNames)
+	}
+}
+
+func deriveField(t reflect.Type, path string, bsonPath string, fields map[string]fieldType, bsonNames map[string]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		fields[path] = fieldDate
+	case t.Kind() == reflect.String:
+		fields[path] = fieldKeyword
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		fields[path] = fieldInteger
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		fields[path] = fieldNumber
+	case t.Kind() == reflect.Struct:
+		fields[path] = fieldObject
+		deriveFields(t, path+".", bsonPath+".", fields, bsonNames)
+	case t.Kind() == reflect.Slice && (t.Elem().Kind() == reflect.Struct && t.Elem() != timeType):
+		fields[path] = fieldObject
+		deriveFields(t.Elem(), path+".", bsonPath+".", fields, bsonNames)
+	case t.Kind() == reflect.Slice:
+		deriveField(t.Elem(), path, bsonPath, fields, bsonNames)
+	case t.Kind() == reflect.Map:
+		fields[path] = fieldObject
+		deriveField(t.Elem(), path+".*", bsonPath+".*", fields, bsonNames)
+	}
+}
+
+// mongoField returns the name of a validated field in MongoDB documents
+func mongoField(field string) string {
+	if name, ok := mongoFieldNames[field]; ok {
+		return name
+	}
+
+	// Keys of maps keep their names
+	if index := strings.LastIndex(field, "."); index > 0 {
+		if name, ok := mongoFieldNames[field[:index]+".*"]; ok {
+			return strings.TrimSuffix(name, "*") + field[index+1:]
+		}
+	}
+
+	return field
+}
+
+// lookupField returns the type of the field. Unknown fields and fields with operators are rejected, so typos
+// do not return empty results and keys like $where never reach a query.
+func lookupField(field string) (fieldType, error) {
+	if field == "" {
+		return 0, &pkg.BadRequestError{Message: "field name cannot be empty"}
+	}
+	if strings.Contains(field, "$") {
+		return 0, &pkg.BadRequestError{Message: fmt.Sprintf("field %q cannot contain operators", field)}
+	}
+
+	if fieldType, ok := orderFields[field]; ok {
+		return fieldType, nil
+	}
+
+	// Keys of maps, like the statuses of statusHistory
+	if index := strings.LastIndex(field, "."); index > 0 {
+		if fieldType, ok := orderFields[field[:index]+".*"]; ok {
+			return fieldType, nil
+		}
+	}
+
+	return 0, &pkg.BadRequestError{Message: fmt.Sprintf("field %q is not a field of orders", field)}
+}
+
+func validateField(field string) error {
+	_, err := lookupField(field)
+	return err
+}
+
+// validateValueField returns the type of a field which is compared by value, objects are rejected
+func validateValueField(field string) (fieldType, error) {
+	fieldType, err := lookupField(field)
+	if err != nil {
+		return 0, err
+	}
+	if fieldType == fieldObject {
+		return 0, &pkg.BadRequestError{Message: fmt.Sprintf("field %q is an object, one of its fields must be used", field)}
+	}
+
+	return fieldType, nil
+}
+
+// coerceValue converts the value of a filter to the type of the field, numbers can be sent as texts and
+// texts as numbers
+func coerceValue(field string, fieldType fieldType, value interface{}) (interface{}, error) {
+	switch fieldType {
+	case fieldKeyword:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	case fieldInteger:
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v), nil
+			}
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case string:
+			if number, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return number, nil
+			}
+		}
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("value %v of %q is not an integer", value, field)}
+	case fieldNumber:
+		if number, ok := toFloat(value); ok {
+			return number, nil
+		}
+		if text, ok := value.(string); ok {
+			if number, err := strconv.ParseFloat(text, 64); err == nil {
+				return number, nil
+			}
+		}
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("value %v of %q is not a number", value, field)}
+	case fieldDate:
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			return parseDate(v)
+		}
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("value %v of %q is not a date", value, field)}
+	}
+
+	return nil, &pkg.BadRequestError{Message: fmt.Sprintf("value %v of %q is not a text", value, field)}
+}
@@ -0,0 +1,158 @@
+package order_api
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLookupField(t *testing.T) {
+	tests := []struct {
+		field     string
+		fieldType fieldType
+		valid     bool
+	}{
+		{"id", fieldKeyword, true},
+		{"total", fieldNumber, true},
+		{"version", fieldInteger, true},
+		{"createdAt", fieldDate, true},
+		{"product", fieldObject, true},
+		{"product.price", fieldNumber, true},
+		{"statusHistory.delivered", fieldDate, true},
+		// Only the json names are fields of the API
+		{"_id", 0, false},
+		{"unknown", 0, false},
+		{"city.$where", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		fieldType, err := lookupField(test.field)
+		if test.valid != (err == nil) {
+			t.Errorf("%q: expected valid %v, got %v", test.field, test.valid, err)
+			continue
+		}
+		if test.valid && fieldType != test.fieldType {
+			t.Errorf("%q: expected type %v, got %v", test.field, test.fieldType, fieldType)
+		}
+	}
+}
+
+func TestMongoField(t *testing.T) {
+	tests := map[string]string{
+		"id":                      "_id",
+		"city":                    "city",
+		"product.price":           "product.price",
+		"statusHistory.delivered": "statusHistory.delivered",
+	}
+
+	for field, expected := range tests {
+		if name := mongoField(field); name != expected {
+			t.Errorf("%q: expected %q, got %q", field, expected, name)
+		}
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	date := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		field     string
+		fieldType fieldType
+		value     interface{}
+		expected  interface{}
+		valid     bool
+	}{
+		{"text", "city", fieldKeyword, "Ankara", "Ankara", true},
+		{"number as text", "city", fieldKeyword, float64(34), "34", true},
+		{"integer", "version", fieldInteger, float64(3), int64(3), true},
+		{"integer as text", "version", fieldInteger, "3", int64(3), true},
+		{"fraction as integer", "version", fieldInteger, 1.5, nil, false},
+		{"number", "total", fieldNumber, float64(100), float64(100), true},
+		{"number as text", "total", fieldNumber, "100.5", 100.5, true},
+		{"text as number", "total", fieldNumber, "abc", nil, false},
+		{"date", "createdAt", fieldDate, "2023-05-01", date, true},
+		{"number as date", "createdAt", fieldDate, float64(1), nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := coerceValue(test.field, test.fieldType, test.value)
+			if test.valid != (err == nil) {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+			if test.valid && !reflect.DeepEqual(value, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, value)
+			}
+		})
+	}
+}
+
+func TestIDFilterIsCompiledToBothBackends(t *testing.T) {
+	query, err := ParseOrderGetRequest(OrderGetRequest{
+		OrderFilter: OrderFilter{
+			ExactFilters:   map[string][]interface{}{"id": {"order-1"}},
+			IncludeDeleted: true,
+		},
+		Sort: []SortRequest{{Field: "id", Direction: "desc"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mongoCompiler MongoCompiler
+	filter, err := mongoCompiler.Filter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (bson.M{"_id": bson.M{"$in": []interface{}{"order-1"}}}); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("expected MongoDB filter %v, got %v", expected, filter)
+	}
+
+	orderBy := mongoCompiler.Sort(query)
+	if orderBy[len(orderBy)-1].Key != "_id" || orderBy[len(orderBy)-1].Direction != -1 {
+		t.Errorf("expected to sort by _id in descending order without another tie-breaker, got %+v", orderBy)
+	}
+
+	elasticQuery, err := query.Filter.Accept(ElasticCompiler{TieBreaker: elasticTieBreaker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"terms": map[string]interface{}{"id": []interface{}{"order-1"}}}
+	if !reflect.DeepEqual(elasticQuery, expected) {
+		t.Errorf("expected Elasticsearch query %v, got %v", expected, elasticQuery)
+	}
+}
+
+func TestRangeValuesAreCoerced(t *testing.T) {
+	query, err := ParseOrderGetRequest(OrderGetRequest{
+		OrderFilter: OrderFilter{
+			Range:          map[string]RangeFilter{"total": {Gte: "100", Lt: float64(500)}},
+			IncludeDeleted: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compiler MongoCompiler
+	filter, err := compiler.Filter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bson.M{"total": bson.M{"$gte": float64(100), "$lt": float64(500)}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("expected %v, got %v", expected, filter)
+	}
+
+	// Texts which are not numbers are still rejected
+	_, err = ParseOrderGetRequest(OrderGetRequest{
+		OrderFilter: OrderFilter{Range: map[string]RangeFilter{"total": {Gte: "now-7d"}}},
+	})
+	if err == nil {
+		t.Error("expected a date bound of a numeric field to be rejected")
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"GenericEndpoint/internal/apps/order-api"
 	"GenericEndpoint/internal/models"
 	"GenericEndpoint/pkg"
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,29 +25,85 @@ func NewHandler(e *echo.Echo, mongoService *order_api.MongoService, elasticServi
 
 	//Routes
 	router.GET("", h.GetAll)
+	router.GET("/trash", h.GetDeletedOrders)
 	router.POST("", h.CreateOrder)
+	router.POST("/bulk", h.BulkCreateOrders)
 	router.POST("/GenericEndpoint", h.GenericEndpoint)
 	router.POST("/GenericEndpointElastic", h.GenericEndpointElastic)
+	router.DELETE("/deleteByQuery", h.DeleteOrdersByQuery)
+	router.POST("/aggregate", h.Aggregate)
+	router.POST("/aggregateElastic", h.AggregateElastic)
+	router.GET("/:id", h.GetOrderByID)
+	router.PUT("/:id", h.UpdateOrder)
+	router.PATCH("/:id", h.PatchOrder)
+	router.POST("/:id/status", h.ChangeOrderStatus)
 	router.DELETE("/:id", h.DeleteOrder)
+	router.POST("/:id/restore", h.RestoreOrder)
+	router.GET("/:id/history", h.GetOrderHistory)
+
+	// Admin routes
+	admin := e.Group("api/admin")
+	admin.POST("/consistency", h.CheckConsistency)
 
 	return h
 }
 
+// actorHeader names the user who makes the request, it is written to the audit trail of the changed orders
+const actorHeader = "X-User-ID"
+
+func actor(c echo.Context) string {
+	if value := c.Request().Header.Get(actorHeader); value != "" {
+		return value
+	}
+
+	return "anonymous"
+}
+
+// setETag returns the version of the order as its entity tag
+func setETag(c echo.Context, version int64) {
+	c.Response().Header().Set("ETag", fmt.Sprintf("\"%d\"", version))
+}
+
+// ifMatchVersion reads the version which the client changes from If-Match. Changes without If-Match are rejected,
+// so a client cannot overwrite an order which it has not read.
+func ifMatchVersion(c echo.Context) (int64, error) {
+	value := c.Request().Header.Get("If-Match")
+	if value == "" {
+		return 0, &pkg.PreconditionRequiredError{Message: "If-Match header with the ETag of the order is required"}
+	}
+
+	// Weak tags are compared like strong tags, the version is the whole state of the order
+	tag := strings.Trim(strings.TrimPrefix(strings.TrimSpace(value), "W/"), "\"")
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 0 {
+		return 0, &pkg.PreconditionFailedError{Message: fmt.Sprintf("If-Match {%v} is not a version of the order", value)}
+	}
+
+	return version, nil
+}
+
 // GetAll godoc
 // @Summary get all order list
 // @ID get-all
 // @Produce json
+// @Param include_deleted query bool false "list soft deleted orders too"
 // @Success 200 {object} models.JSONSuccessResultData
-// @Success 500 {object} pkg.InternalServerError
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
 // @Router /orders [get]
 func (h *Handler) GetAll(c echo.Context) error {
-	orderList, err := h.MongoService.GetAll()
+	includeDeleted := false
+	if value := c.QueryParam("include_deleted"); value != "" {
+		var err error
+		if includeDeleted, err = strconv.ParseBool(value); err != nil {
+			return &pkg.BadRequestError{Message: "include_deleted must be true or false"}
+		}
+	}
+
+	orderList, err := h.MongoService.GetAll(includeDeleted)
 
 	if err != nil {
-		c.Logger().Errorf("StatusInternalServerError: %v", err)
-		return c.JSON(http.StatusInternalServerError, pkg.InternalServerError{
-			Message: "Something went wrong!",
-		})
+		return err
 	}
 
 	// Response success result data
@@ -57,34 +116,66 @@ func (h *Handler) GetAll(c echo.Context) error {
 	return c.JSON(http.StatusOK, jsonSuccessResultData)
 }
 
+// GetDeletedOrders godoc
+// @Summary get soft deleted orders
+// @ID get-deleted-orders
+// @Produce json
+// @Param limit query int false "number of orders, default and max like the generic endpoint"
+// @Param offset query int false "number of orders to skip"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} models.JSONSuccessResultData
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/trash [get]
+func (h *Handler) GetDeletedOrders(c echo.Context) error {
+	var limit, offset int
+	for name, value := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if param := c.QueryParam(name); param != "" {
+			var err error
+			if *value, err = strconv.Atoi(param); err != nil {
+				return &pkg.BadRequestError{Message: fmt.Sprintf("%v must be a number", name)}
+			}
+		}
+	}
+
+	orderList, total, nextCursor, err := h.MongoService.GetDeleted(limit, offset, c.QueryParam("cursor"))
+
+	if err != nil {
+		return err
+	}
+
+	// Response success result data
+	jsonSuccessResultData := models.JSONSuccessResultData{
+		TotalItemCount: int(total),
+		Data:           orderList,
+		NextCursor:     nextCursor,
+	}
+
+	c.Logger().Info("Deleted orders are successfully listed.")
+	return c.JSON(http.StatusOK, jsonSuccessResultData)
+}
+
 // GenericEndpoint godoc
 // @Summary get orders list with filter
 // @ID get-orders-with-filter
 // @Produce json
 // @Param data body order_api.OrderGetRequest true "order filter data"
 // @Success 200 {object} models.JSONSuccessResultData
-// @Success 400 {object} pkg.BadRequestError
-// @Success 404 {object} pkg.NotFoundError
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
 // @Router /orders/GenericEndpoint [post]
 func (h *Handler) GenericEndpoint(c echo.Context) error {
 	var orderGetRequest order_api.OrderGetRequest
 
 	if err := c.Bind(&orderGetRequest); err != nil {
-		c.Logger().Errorf("Bad Request. It cannot be binding! %v", err.Error())
-		return c.JSON(http.StatusBadRequest, pkg.BadRequestError{
-			Message: fmt.Sprintf("Bad Request. It cannot be binding! %v", err.Error()),
-		})
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
 	}
 
-	// Create filter and find options (exact filter,sort,field and match)
-	filter, findOptions := h.MongoService.FromModelConvertToFilter(orderGetRequest)
-	orderList, err := h.MongoService.GetOrdersWithFilter(filter, findOptions)
+	// Create filter and find options (exact filter,sort,field,match and page) and get the page
+	orderList, total, nextCursor, err := h.MongoService.GetOrdersPage(orderGetRequest)
 
 	if err != nil {
-		c.Logger().Errorf("NotFoundError. %v", err.Error())
-		return c.JSON(http.StatusNotFound, pkg.NotFoundError{
-			Message: fmt.Sprintf("NotFoundError. %v", err.Error()),
-		})
+		return err
 	}
 
 	var orderResponse order_api.OrderResponse
@@ -98,6 +189,8 @@ func (h *Handler) GenericEndpoint(c echo.Context) error {
 		orderResponse.AddressDetail = order.AddressDetail
 		orderResponse.Product = order.Product
 		orderResponse.Total = order.Total
+		orderResponse.StatusHistory = order.StatusHistory
+		orderResponse.Version = order.Version
 
 		if order.CreatedAt.String() == "0001-01-01 00:00:00 +0000 UTC" {
 			orderResponse.CreatedAt = ""
@@ -111,13 +204,20 @@ func (h *Handler) GenericEndpoint(c echo.Context) error {
 			orderResponse.UpdatedAt = order.UpdatedAt.String()
 		}
 
+		if order.IsDeleted() {
+			orderResponse.DeletedAt = order.DeletedAt.String()
+		} else {
+			orderResponse.DeletedAt = ""
+		}
+
 		orderResponseList = append(orderResponseList, orderResponse)
 	}
 
 	// Response success result data
 	jsonSuccessResultData := models.JSONSuccessResultData{
-		TotalItemCount: len(orderResponseList),
+		TotalItemCount: int(total),
 		Data:           orderResponseList,
+		NextCursor:     nextCursor,
 	}
 
 	c.Logger().Info("Orders are successfully listed.")
@@ -130,92 +230,435 @@ func (h *Handler) GenericEndpoint(c echo.Context) error {
 // @Produce json
 // @Param data body order_api.OrderGetRequest true "order filter data"
 // @Success 200 {object} models.JSONSuccessResultData
-// @Success 400 {object} pkg.BadRequestError
-// @Success 404 {object} pkg.NotFoundError
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
 // @Router /orders/GenericEndpointElastic [post]
 func (h *Handler) GenericEndpointElastic(c echo.Context) error {
 	var orderGetRequest order_api.OrderGetRequest
 
 	if err := c.Bind(&orderGetRequest); err != nil {
-		c.Logger().Errorf("Bad Request. It cannot be binding! %v", err.Error())
-		return c.JSON(http.StatusBadRequest, pkg.BadRequestError{
-			Message: fmt.Sprintf("Bad Request. It cannot be binding! %v", err.Error()),
-		})
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
 	}
 
 	// Create filter and find options (exact filter,sort,field and match)
-	orderList, err := h.ElasticService.GetFromElasticsearch(orderGetRequest)
+	orderList, total, nextCursor, err := h.ElasticService.GetFromElasticsearch(orderGetRequest)
 	if err != nil {
-		c.Logger().Errorf("InternalServerError. %v", err.Error())
-		return c.JSON(http.StatusInternalServerError, pkg.InternalServerError{
-			Message: fmt.Sprintf("InternalServerError. %v", err.Error()),
-		})
+		return err
 	}
 
 	// Response success result data
 	jsonSuccessResultData := models.JSONSuccessResultData{
-		TotalItemCount: len(orderList),
+		TotalItemCount: int(total),
 		Data:           orderList,
+		NextCursor:     nextCursor,
 	}
 
 	c.Logger().Info("Orders are successfully listed.")
 	return c.JSON(http.StatusOK, jsonSuccessResultData)
 }
 
+// Aggregate godoc
+// @Summary group filtered orders and calculate metrics
+// @ID aggregate-orders
+// @Produce json
+// @Param data body order_api.OrderAggregateRequest true "order aggregation data"
+// @Success 200 {object} models.JSONSuccessResultData
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/aggregate [post]
+func (h *Handler) Aggregate(c echo.Context) error {
+	var orderAggregateRequest order_api.OrderAggregateRequest
+
+	if err := c.Bind(&orderAggregateRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	buckets, err := h.MongoService.Aggregate(orderAggregateRequest)
+	if err != nil {
+		return err
+	}
+
+	// Response success result data
+	jsonSuccessResultData := models.JSONSuccessResultData{
+		TotalItemCount: len(buckets),
+		Data:           buckets,
+	}
+
+	c.Logger().Info("Orders are successfully aggregated.")
+	return c.JSON(http.StatusOK, jsonSuccessResultData)
+}
+
+// AggregateElastic godoc
+// @Summary group filtered orders and calculate metrics
+// @ID aggregate-orders-from-elastic
+// @Produce json
+// @Param data body order_api.OrderAggregateRequest true "order aggregation data"
+// @Success 200 {object} models.JSONSuccessResultData
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/aggregateElastic [post]
+func (h *Handler) AggregateElastic(c echo.Context) error {
+	var orderAggregateRequest order_api.OrderAggregateRequest
+
+	if err := c.Bind(&orderAggregateRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	buckets, err := h.ElasticService.AggregateFromElasticsearch(orderAggregateRequest)
+	if err != nil {
+		return err
+	}
+
+	// Response success result data
+	jsonSuccessResultData := models.JSONSuccessResultData{
+		TotalItemCount: len(buckets),
+		Data:           buckets,
+	}
+
+	c.Logger().Info("Orders are successfully aggregated.")
+	return c.JSON(http.StatusOK, jsonSuccessResultData)
+}
+
 // CreateOrder godoc
 // @Summary add a new item to the order list
 // @ID create-order
 // @Produce json
 // @Param data body order_api.OrderCreateRequest true "order data"
+// @Param Idempotency-Key header string false "key of the request, a retry with the same key returns the same order"
 // @Success 201 {object} models.JSONSuccessResultId
-// @Success 400 {object} pkg.BadRequestError
-// @Success 500 {object} pkg.InternalServerError
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 409 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
 // @Router /orders [post]
 func (h *Handler) CreateOrder(c echo.Context) error {
 	var orderCreateRequest order_api.OrderCreateRequest
 
 	if err := c.Bind(&orderCreateRequest); err != nil {
-		c.Logger().Errorf("Bad Request. It cannot be binding! %v", err.Error())
-		return c.JSON(http.StatusBadRequest, pkg.BadRequestError{
-			Message: fmt.Sprintf("Bad Request. It cannot be binding! %v", err.Error()),
-		})
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	// New orders start as created
+	orderModel, err := order_api.NewOrder(orderCreateRequest, time.Now())
+	if err != nil {
+		return err
+	}
+
+	idempotencyKey := c.Request().Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > order_api.MaxIdempotencyKeyLength {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("Idempotency-Key is longer than %d characters", order_api.MaxIdempotencyKeyLength)}
+	}
+
+	// Elasticsearch is updated from the outbox event which is written with the order
+	var orderID string
+	if idempotencyKey == "" {
+		var result models.Order
+		result, err = h.MongoService.Insert(orderModel, actor(c))
+		orderID = result.ID
+	} else {
+		var requestHash string
+		if requestHash, err = order_api.HashRequest(orderCreateRequest); err == nil {
+			orderID, err = h.MongoService.InsertWithIdempotencyKey(orderModel, idempotencyKey, requestHash, actor(c))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// To response id and success boolean
+	jsonSuccessResultId := models.JSONSuccessResultId{
+		ID:      orderID,
+		Success: true,
+	}
+
+	// A retried request returns the order which is created by the first request
+	if orderID != orderModel.ID {
+		c.Response().Header().Set("Idempotent-Replayed", "true")
+		c.Logger().Infof("{%v} with id is returned for idempotency key {%v}.", jsonSuccessResultId.ID, idempotencyKey)
+		return c.JSON(http.StatusCreated, jsonSuccessResultId)
+	}
+
+	c.Logger().Infof("{%v} with id is created.", jsonSuccessResultId.ID)
+	return c.JSON(http.StatusCreated, jsonSuccessResultId)
+}
+
+// BulkCreateOrders godoc
+// @Summary add many orders with one request
+// @ID bulk-create-orders
+// @Produce json
+// @Param data body order_api.OrderBulkCreateRequest true "orders"
+// @Success 200 {object} models.JSONSuccessResultData
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/bulk [post]
+func (h *Handler) BulkCreateOrders(c echo.Context) error {
+	var orderBulkCreateRequest order_api.OrderBulkCreateRequest
+
+	if err := c.Bind(&orderBulkCreateRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	if len(orderBulkCreateRequest.Orders) == 0 || len(orderBulkCreateRequest.Orders) > order_api.MaxBulkOrders {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("Bulk request must have 1 to %d orders.", order_api.MaxBulkOrders)}
+	}
+
+	results := make([]order_api.BulkOrderResult, len(orderBulkCreateRequest.Orders))
+	orders := make([]models.Order, 0, len(orderBulkCreateRequest.Orders))
+	// Index of every order in the request
+	positions := make([]int, 0, len(orderBulkCreateRequest.Orders))
+
+	now := time.Now()
+	for i, orderCreateRequest := range orderBulkCreateRequest.Orders {
+		results[i].Index = i
+
+		orderModel, err := order_api.NewOrder(orderCreateRequest, now)
+		if err != nil {
+			results[i].Error = err.Error()
+			// Ordered requests stop at the first invalid order
+			if orderBulkCreateRequest.Ordered {
+				for j := i + 1; j < len(results); j++ {
+					results[j].Index = j
+					results[j].Error = "not inserted because a previous order failed"
+				}
+				break
+			}
+			continue
+		}
+
+		results[i].ID = orderModel.ID
+		orders = append(orders, orderModel)
+		positions = append(positions, i)
+	}
+
+	failed, err := h.MongoService.InsertMany(orders, orderBulkCreateRequest.Ordered, actor(c))
+	if err != nil {
+		return err
+	}
+
+	inserted := make([]models.Order, 0, len(orders))
+	for i, orderModel := range orders {
+		if reason, ok := failed[i]; ok {
+			results[positions[i]].Error = reason
+			continue
+		}
+		results[positions[i]].Success = true
+		inserted = append(inserted, orderModel)
+	}
+
+	// Inserted orders have outbox events, they are indexed with one bulk request so most of them are searchable
+	// when the response is sent. The ones which cannot be indexed now are indexed from the outbox.
+	notIndexed, err := h.ElasticService.BulkSaveOrders(inserted)
+	if err != nil {
+		c.Logger().Errorf("Orders could not be indexed: %v", err)
+		notIndexed = make(map[string]string)
+		for _, orderModel := range inserted {
+			notIndexed[orderModel.ID] = err.Error()
+		}
+	}
+
+	for i, result := range results {
+		if _, ok := notIndexed[result.ID]; result.Success && !ok {
+			results[i].Indexed = true
+		}
+	}
+
+	// Response success result data
+	jsonSuccessResultData := models.JSONSuccessResultData{
+		TotalItemCount: len(inserted),
+		Data:           results,
+	}
+
+	c.Logger().Infof("%d of %d orders are created.", len(inserted), len(results))
+	return c.JSON(http.StatusOK, jsonSuccessResultData)
+}
+
+// GetOrderByID godoc
+// @Summary get an order item by ID
+// @ID get-order-by-id
+// @Produce json
+// @Param id path string true "order ID"
+// @Success 200 {object} models.Order
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Header 200 {string} ETag "version of the order"
+// @Router /orders/{id} [get]
+func (h *Handler) GetOrderByID(c echo.Context) error {
+	query := c.Param("id")
+
+	order, err := h.MongoService.GetByID(query)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Infof("{%v} with id is listed.", order.ID)
+	setETag(c, order.Version)
+	return c.JSON(http.StatusOK, order)
+}
+
+// UpdateOrder godoc
+// @Summary replace an order item by ID
+// @ID update-order-by-id
+// @Produce json
+// @Param id path string true "order ID"
+// @Param data body order_api.OrderUpdateRequest true "order data"
+// @Param If-Match header string true "ETag of the order which is changed"
+// @Success 200 {object} models.JSONSuccessResultId
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Failure 412 {object} pkg.ProblemDetails
+// @Failure 428 {object} pkg.ProblemDetails
+// @Router /orders/{id} [put]
+func (h *Handler) UpdateOrder(c echo.Context) error {
+	query := c.Param("id")
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var orderUpdateRequest order_api.OrderUpdateRequest
+
+	if err := c.Bind(&orderUpdateRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	if err := order_api.Validate(orderUpdateRequest); err != nil {
+		return err
+	}
+
+	orderModel, err := h.MongoService.GetByID(query)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// ID, CreatedAt and status history are kept, other fields are replaced
+	orderModel.UserID = orderUpdateRequest.UserID
+	orderModel.City = orderUpdateRequest.City
+	orderModel.AddressDetail = orderUpdateRequest.AddressDetail
+	orderModel.Product = order_api.ProductLines(orderUpdateRequest.Product)
+	orderModel.UpdatedAt = time.Now()
+	orderModel.CalculateTotal()
+
+	// Status can only change with an allowed transition, an empty status keeps the current one
+	if orderUpdateRequest.Status != "" && orderUpdateRequest.Status != orderModel.Status {
+		if err := orderModel.ChangeStatus(orderUpdateRequest.Status, orderModel.UpdatedAt); err != nil {
+			return &pkg.BadRequestError{Message: err.Error()}
+		}
+	}
+
+	result, err := h.MongoService.Replace(orderModel, version, actor(c))
+
+	if err != nil {
+		return err
+	}
+
+	// To response id and success boolean
+	jsonSuccessResultId := models.JSONSuccessResultId{
+		ID:      result.ID,
+		Success: true,
+	}
+
+	c.Logger().Infof("{%v} with id is updated.", jsonSuccessResultId.ID)
+	setETag(c, result.Version)
+	return c.JSON(http.StatusOK, jsonSuccessResultId)
+}
+
+// PatchOrder godoc
+// @Summary update fields of an order item by ID
+// @ID patch-order-by-id
+// @Produce json
+// @Param id path string true "order ID"
+// @Param data body order_api.OrderPatchRequest true "order fields"
+// @Param If-Match header string true "ETag of the order which is changed"
+// @Success 200 {object} models.JSONSuccessResultId
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Failure 412 {object} pkg.ProblemDetails
+// @Failure 428 {object} pkg.ProblemDetails
+// @Router /orders/{id} [patch]
+func (h *Handler) PatchOrder(c echo.Context) error {
+	query := c.Param("id")
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
 	}
 
-	var orderModel models.Order
+	var orderPatchRequest order_api.OrderPatchRequest
 
-	orderModel.UserID = orderCreateRequest.UserID
-	orderModel.Status = orderCreateRequest.Status
-	orderModel.City = orderCreateRequest.City
-	orderModel.AddressDetail = orderCreateRequest.AddressDetail
-	orderModel.Product = orderCreateRequest.Product
+	if err := c.Bind(&orderPatchRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
 
-	// Create id and created date value
-	orderModel.ID = uuid.New().String()
-	orderModel.CreatedAt = time.Now()
-	// We don't want to set null, so we put CreatedAt value.
-	orderModel.UpdatedAt = orderModel.CreatedAt
+	result, err := h.MongoService.Patch(query, orderPatchRequest, version, actor(c))
 
-	var total float64
-	for _, product := range orderModel.Product {
-		total = product.Price * float64(product.Quantity)
-		orderModel.Total += total
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
 	}
 
-	result, err := h.MongoService.Insert(orderModel)
+	if err != nil {
+		return err
+	}
+
+	// To response id and success boolean
+	jsonSuccessResultId := models.JSONSuccessResultId{
+		ID:      result.ID,
+		Success: true,
+	}
+
+	c.Logger().Infof("{%v} with id is patched.", jsonSuccessResultId.ID)
+	setETag(c, result.Version)
+	return c.JSON(http.StatusOK, jsonSuccessResultId)
+}
+
+// ChangeOrderStatus godoc
+// @Summary move an order item to another status
+// @ID change-order-status
+// @Produce json
+// @Param id path string true "order ID"
+// @Param data body order_api.OrderStatusRequest true "new status"
+// @Param If-Match header string true "ETag of the order which is changed"
+// @Success 200 {object} models.JSONSuccessResultId
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Failure 412 {object} pkg.ProblemDetails
+// @Failure 428 {object} pkg.ProblemDetails
+// @Router /orders/{id}/status [post]
+func (h *Handler) ChangeOrderStatus(c echo.Context) error {
+	query := c.Param("id")
 
+	version, err := ifMatchVersion(c)
 	if err != nil {
-		c.Logger().Errorf("StatusInternalServerError: %v", err)
-		return c.JSON(http.StatusInternalServerError, pkg.InternalServerError{
-			Message: "Something went wrong!",
-		})
+		return err
+	}
+
+	var orderStatusRequest order_api.OrderStatusRequest
+
+	if err := c.Bind(&orderStatusRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
 	}
 
-	// Save to elasticsearch
-	if err := h.ElasticService.SaveOrderToElasticsearch(orderModel); err != nil {
-		c.Logger().Errorf("StatusInternalServerError (Elasticsearch) : %v", err)
-		return c.JSON(http.StatusInternalServerError, pkg.InternalServerError{
-			Message: "Something went wrong with elasticsearch!",
-		})
+	result, err := h.MongoService.ChangeStatus(query, orderStatusRequest.Status, version, actor(c))
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
+	}
+
+	if err != nil {
+		return err
 	}
 
 	// To response id and success boolean
@@ -224,8 +667,9 @@ func (h *Handler) CreateOrder(c echo.Context) error {
 		Success: true,
 	}
 
-	c.Logger().Infof("{%v} with id is created.", jsonSuccessResultId.ID)
-	return c.JSON(http.StatusCreated, jsonSuccessResultId)
+	c.Logger().Infof("{%v} with id is moved to {%v}.", jsonSuccessResultId.ID, result.Status)
+	setETag(c, result.Version)
+	return c.JSON(http.StatusOK, jsonSuccessResultId)
 }
 
 // DeleteOrder godoc
@@ -233,27 +677,33 @@ func (h *Handler) CreateOrder(c echo.Context) error {
 // @ID delete-order-by-id
 // @Produce json
 // @Param id path string true "order ID"
+// @Param If-Match header string true "ETag of the order which is changed"
 // @Success 200 {object} models.JSONSuccessResultId
-// @Success 404 {object} pkg.NotFoundError
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 412 {object} pkg.ProblemDetails
+// @Failure 428 {object} pkg.ProblemDetails
 // @Router /orders/{id} [delete]
 func (h *Handler) DeleteOrder(c echo.Context) error {
 	query := c.Param("id")
 
-	result, err := h.MongoService.Delete(query)
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	// Order is soft deleted, Elasticsearch is updated from the outbox event which is written with the deletion
+	result, err := h.MongoService.Delete(query, version, actor(c))
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
+	}
 
-	if err != nil || result == false {
-		c.Logger().Errorf("NotFoundError. %v", err.Error())
-		return c.JSON(http.StatusNotFound, pkg.NotFoundError{
-			Message: fmt.Sprintf("NotFoundError. %v", err.Error()),
-		})
+	if err != nil {
+		return err
 	}
 
-	// Delete from elasticsearch
-	if err := h.ElasticService.DeleteOrderFromElasticsearch(query); err != nil {
-		c.Logger().Errorf("StatusInternalServerError (Elasticsearch) : %v", err)
-		return c.JSON(http.StatusInternalServerError, pkg.InternalServerError{
-			Message: "Something went wrong with elasticsearch!",
-		})
+	if !result {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
 	}
 
 	// To response id and success boolean
@@ -263,5 +713,163 @@ func (h *Handler) DeleteOrder(c echo.Context) error {
 	}
 
 	c.Logger().Infof("{%v} with id is deleted.", jsonSuccessResultId.ID)
-	return c.JSON(http.StatusCreated, jsonSuccessResultId)
+	return c.JSON(http.StatusOK, jsonSuccessResultId)
+}
+
+// RestoreOrder godoc
+// @Summary restore a soft deleted order by ID
+// @ID restore-order-by-id
+// @Produce json
+// @Param id path string true "order ID"
+// @Success 200 {object} models.JSONSuccessResultId
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/{id}/restore [post]
+func (h *Handler) RestoreOrder(c echo.Context) error {
+	query := c.Param("id")
+
+	// Elasticsearch is updated from the outbox event which is written with the order
+	result, err := h.MongoService.Restore(query, actor(c))
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Deleted order {%v} is not found.", query)}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// To response id and success boolean
+	jsonSuccessResultId := models.JSONSuccessResultId{
+		ID:      result.ID,
+		Success: true,
+	}
+
+	c.Logger().Infof("{%v} with id is restored.", jsonSuccessResultId.ID)
+	setETag(c, result.Version)
+	return c.JSON(http.StatusOK, jsonSuccessResultId)
+}
+
+// GetOrderHistory godoc
+// @Summary list the changes of an order, the last change is the first
+// @ID get-order-history
+// @Produce json
+// @Param id path string true "order ID"
+// @Param limit query int false "number of changes, default 100 and max 1000"
+// @Param offset query int false "number of changes to skip"
+// @Success 200 {object} models.JSONSuccessResultData
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 404 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/{id}/history [get]
+func (h *Handler) GetOrderHistory(c echo.Context) error {
+	query := c.Param("id")
+
+	var limit, offset int
+	for name, value := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if param := c.QueryParam(name); param != "" {
+			var err error
+			if *value, err = strconv.Atoi(param); err != nil {
+				return &pkg.BadRequestError{Message: fmt.Sprintf("%v must be a number", name)}
+			}
+		}
+	}
+
+	entries, total, err := h.MongoService.GetHistory(query, limit, offset)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pkg.NotFoundError{Message: fmt.Sprintf("Order {%v} is not found.", query)}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Response success result data
+	jsonSuccessResultData := models.JSONSuccessResultData{
+		TotalItemCount: int(total),
+		Data:           entries,
+	}
+
+	c.Logger().Infof("%d changes of {%v} are listed.", len(entries), query)
+	return c.JSON(http.StatusOK, jsonSuccessResultData)
+}
+
+// DeleteOrdersByQuery godoc
+// @Summary soft delete orders matching a filter
+// @ID delete-orders-by-query
+// @Accept json
+// @Produce json
+// @Param data body order_api.OrderDeleteByQueryRequest true "order filter data"
+// @Success 200 {object} order_api.DeleteByQueryResult
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /orders/deleteByQuery [delete]
+func (h *Handler) DeleteOrdersByQuery(c echo.Context) error {
+	var orderDeleteByQueryRequest order_api.OrderDeleteByQueryRequest
+
+	if err := c.Bind(&orderDeleteByQueryRequest); err != nil {
+		return &pkg.BadRequestError{Message: fmt.Sprintf("It cannot be binding! %v", err.Error())}
+	}
+
+	ids, filter, err := h.MongoService.FindOrdersToDelete(orderDeleteByQueryRequest)
+	if err != nil {
+		return err
+	}
+
+	result := order_api.DeleteByQueryResult{
+		DryRun:  orderDeleteByQueryRequest.DryRun,
+		Matched: int64(len(ids)),
+		IDs:     ids,
+	}
+
+	if orderDeleteByQueryRequest.DryRun {
+		c.Logger().Infof("%d orders match the delete by query request.", result.Matched)
+		return c.JSON(http.StatusOK, result)
+	}
+
+	// Orders are soft deleted, so they can be restored and they are removed by the purge job after the retention
+	var deleted []models.Order
+	result.Deleted, deleted, err = h.MongoService.SoftDeleteMany(ids, filter, actor(c))
+	if err != nil {
+		return err
+	}
+
+	// Outbox events are written with the deletion, so the index is updated later if this request fails
+	notIndexed, err := h.ElasticService.BulkSaveOrders(deleted)
+	if err != nil {
+		c.Logger().Errorf("Deleted orders could not be indexed: %v", err)
+	} else {
+		result.ElasticIndexed = int64(len(deleted) - len(notIndexed))
+	}
+
+	c.Logger().Infof("%d orders are deleted by query.", result.Deleted)
+	return c.JSON(http.StatusOK, result)
+}
+
+// CheckConsistency godoc
+// @Summary compare orders in mongodb and elasticsearch
+// @ID check-consistency
+// @Produce json
+// @Param repair query bool false "index missing and stale orders again and delete extra orders"
+// @Success 200 {object} order_api.ConsistencyReport
+// @Failure 400 {object} pkg.ProblemDetails
+// @Failure 500 {object} pkg.ProblemDetails
+// @Router /admin/consistency [post]
+func (h *Handler) CheckConsistency(c echo.Context) error {
+	repair := false
+	if value := c.QueryParam("repair"); value != "" {
+		var err error
+		if repair, err = strconv.ParseBool(value); err != nil {
+			return &pkg.BadRequestError{Message: "repair must be true or false"}
+		}
+	}
+
+	report, err := order_api.NewConsistencyChecker(h.MongoService.Repository, h.ElasticService).Check(repair)
+	if err != nil {
+		return err
+	}
+
+	c.Logger().Info("Consistency of orders is checked.")
+	return c.JSON(http.StatusOK, report)
 }
@@ -0,0 +1,509 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MongoCompiler compiles a Query to a MongoDB filter and find options
+type MongoCompiler struct {
+	// Path of the array inside $elemMatch, fields are relative to its elements there
+	elemPath string
+}
+
+// Filter compiles the filter tree of the query, an empty filter matches every document
+func (c MongoCompiler) Filter(query *Query) (bson.M, error) {
+	filter, err := query.Filter.Accept(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.(bson.M), nil
+}
+
+// mongoSortKey is one key of the MongoDB sort order. Value is the expression of a computed key, keys without it
+// are fields of the document. Computed keys stay in the documents of the page, so the cursor can be read from them.
+type mongoSortKey struct {
+	Key       string
+	Direction int
+	Value     interface{}
+}
+
+// Pipeline creates the aggregation pipeline of one page: filter, cursor condition, sort, page and projection
+func (c MongoCompiler) Pipeline(query *Query, filter bson.M) (mongo.Pipeline, error) {
+	orderBy := c.Sort(query)
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+
+	// Add the flags of missing values and the sort values of array fields
+	computed := bson.D{}
+	for _, key := range orderBy {
+		if key.Value != nil {
+			computed = append(computed, bson.E{Key: key.Key, Value: key.Value})
+		}
+	}
+	if len(computed) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: computed}})
+	}
+
+	// Continue after the last item of the previous page
+	if len(query.After) > 0 {
+		searchAfter, err := c.SearchAfter(query)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: searchAfter}})
+	}
+
+	sort := bson.D{}
+	for _, key := range orderBy {
+		sort = append(sort, bson.E{Key: key.Key, Value: key.Direction})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+
+	if query.Offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: query.Offset}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$limit", Value: query.Limit}})
+
+	// Add projection criteria if provided, sort keys are needed for the next cursor
+	if len(query.Fields) > 0 {
+		projection := bson.M{}
+		for _, field := range query.Fields {
+			projection[mongoField(field)] = 1
+		}
+		for _, key := range orderBy {
+			projection[key.Key] = 1
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+
+	return pipeline, nil
+}
+
+// Sort returns the sort order of the query, _id is added as tie-breaker for pagination. Every field gets a flag
+// of missing values before it, so missing values are placed as requested and the cursor never compares with null.
+// Array fields like product.price are sorted by their smallest value in ascending and largest value in descending
+// order like in Elasticsearch.
+func (c MongoCompiler) Sort(query *Query) []mongoSortKey {
+	orderBy := make([]mongoSortKey, 0, 2*len(query.Sort)+1)
+	hasID := false
+	for i, clause := range query.Sort {
+		direction := 1
+		if clause.Descending {
+			direction = -1
+		}
+
+		field := mongoField(clause.Field)
+		key := mongoSortKey{Key: field, Direction: direction}
+		var value interface{} = "$" + field
+		if nestedPath(clause.Field) != "" {
+			reduce := "$min"
+			if clause.Descending {
+				reduce = "$max"
+			}
+			value = bson.M{reduce: "$" + field}
+			key = mongoSortKey{Key: "_sort" + strconv.Itoa(i), Direction: direction, Value: value}
+		}
+
+		flagDirection := 1
+		if clause.MissingFirst {
+			flagDirection = -1
+		}
+		orderBy = append(orderBy, mongoSortKey{
+			Key:       "_missing" + strconv.Itoa(i),
+			Direction: flagDirection,
+			Value:     bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{value, nil}}, nil}}, 1, 0}},
+		}, key)
+		hasID = hasID || field == "_id"
+	}
+
+	if !hasID {
+		orderBy = append(orderBy, mongoSortKey{Key: "_id", Direction: 1})
+	}
+
+	return orderBy
+}
+
+// SearchAfter creates the keyset condition that selects documents coming after the cursor of the query
+func (c MongoCompiler) SearchAfter(query *Query) (bson.M, error) {
+	orderBy := c.Sort(query)
+	if len(query.After) != len(orderBy) {
+		return nil, &pkg.BadRequestError{Message: "cursor does not match the sort of the request"}
+	}
+
+	conditions := make([]bson.M, 0, len(orderBy))
+	for i, key := range orderBy {
+		// Documents without the value are only ordered by the keys after it, the flag before it orders them
+		if query.After[i] == nil {
+			continue
+		}
+
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[orderBy[j].Key] = query.After[j]
+		}
+
+		operator := "$gt"
+		if key.Direction == -1 {
+			operator = "$lt"
+		}
+		condition[key.Key] = bson.M{operator: query.After[i]}
+
+		conditions = append(conditions, condition)
+	}
+
+	return bson.M{"$or": conditions}, nil
+}
+
+// SortValues reads the values of the sort keys from a document to create the next cursor, missing values are nil
+func (c MongoCompiler) SortValues(query *Query, document bson.Raw) ([]interface{}, error) {
+	orderBy := c.Sort(query)
+	values := make([]interface{}, 0, len(orderBy))
+	for _, key := range orderBy {
+		rawValue, err := document.LookupErr(strings.Split(key.Key, ".")...)
+		if err != nil || rawValue.Type == bsontype.Null {
+			values = append(values, nil)
+			continue
+		}
+
+		var value interface{}
+		if err := rawValue.Unmarshal(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// AggregationPipeline creates the aggregation pipeline which groups the filtered orders and calculates the metrics
+func (c MongoCompiler) AggregationPipeline(aggregation *Aggregation, filter bson.M) mongo.Pipeline {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+
+	// Product metrics are reduced per order first, $group ignores the arrays of product fields otherwise
+	lineValues := bson.D{}
+	for i, metric := range aggregation.Metrics {
+		if !metric.IsProductMetric() {
+			continue
+		}
+
+		name := "_m" + strconv.Itoa(i)
+		if metric.Type == MetricAvg {
+			lineValues = append(lineValues,
+				bson.E{Key: name + "_sum", Value: bson.M{"$sum": "$" + mongoField(metric.Field)}},
+				bson.E{Key: name + "_count", Value: bson.M{"$size": bson.M{"$ifNull": bson.A{"$product", bson.A{}}}}},
+			)
+		} else {
+			lineValues = append(lineValues, bson.E{Key: name, Value: bson.M{"$" + metric.Type: "$" + mongoField(metric.Field)}})
+		}
+	}
+	if len(lineValues) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: lineValues}})
+	}
+
+	// Group keys are named by position, field names can contain dots
+	var groupKey interface{}
+	if len(aggregation.GroupBy) > 0 || aggregation.Histogram != nil {
+		key := bson.D{}
+		for i, field := range aggregation.GroupBy {
+			key = append(key, bson.E{Key: "g" + strconv.Itoa(i), Value: "$" + mongoField(field)})
+		}
+		if histogram := aggregation.Histogram; histogram != nil {
+			key = append(key, bson.E{Key: "h", Value: bson.M{"$dateTrunc": bson.M{
+				"date":        "$" + mongoField(histogram.Field),
+				"unit":        histogram.Interval,
+				"timezone":    histogram.TimeZone,
+				"startOfWeek": "monday",
+			}}})
+		}
+		groupKey = key
+	}
+
+	group := bson.D{{Key: "_id", Value: groupKey}, {Key: "count", Value: bson.M{"$sum": 1}}}
+	metrics := bson.M{}
+	for i, metric := range aggregation.Metrics {
+		name := "_m" + strconv.Itoa(i)
+
+		switch {
+		case metric.Type == MetricCount:
+			metrics[metric.Name] = "$count"
+			continue
+		case metric.IsProductMetric() && metric.Type == MetricAvg:
+			// Average of every product line, not the average of order averages
+			group = append(group,
+				bson.E{Key: name + "_sum", Value: bson.M{"$sum": "$" + name + "_sum"}},
+				bson.E{Key: name + "_count", Value: bson.M{"$sum": "$" + name + "_count"}},
+			)
+			metrics[metric.Name] = bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$" + name + "_count", 0}},
+				nil,
+				bson.M{"$divide": bson.A{"$" + name + "_sum", "$" + name + "_count"}},
+			}}
+			continue
+		case metric.IsProductMetric():
+			group = append(group, bson.E{Key: name, Value: bson.M{"$" + metric.Type: "$" + name}})
+		default:
+			group = append(group, bson.E{Key: name, Value: bson.M{"$" + metric.Type: "$" + mongoField(metric.Field)}})
+		}
+		metrics[metric.Name] = "$" + name
+	}
+
+	projection := bson.M{"_id": 0, "key": "$_id", "count": 1}
+	if len(metrics) > 0 {
+		projection["metrics"] = metrics
+	}
+
+	return append(pipeline,
+		bson.D{{Key: "$group", Value: group}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		bson.D{{Key: "$limit", Value: MaxBuckets}},
+		bson.D{{Key: "$project", Value: projection}},
+	)
+}
+
+// AggregateBuckets converts the documents of the aggregation pipeline to buckets
+func (c MongoCompiler) AggregateBuckets(aggregation *Aggregation, documents []bson.Raw) ([]AggregateBucket, error) {
+	buckets := make([]AggregateBucket, 0, len(documents))
+	for _, document := range documents {
+		var result struct {
+			Key     bson.M `bson:"key"`
+			Count   int64  `bson:"count"`
+			Metrics bson.M `bson:"metrics"`
+		}
+		if err := bson.Unmarshal(document, &result); err != nil {
+			return nil, err
+		}
+
+		bucket := AggregateBucket{Key: map[string]interface{}{}, Count: result.Count, Metrics: map[string]interface{}{}}
+		for name, value := range result.Metrics {
+			bucket.Metrics[name] = value
+		}
+		for i, field := range aggregation.GroupBy {
+			bucket.Key[field] = result.Key["g"+strconv.Itoa(i)]
+		}
+		if aggregation.Histogram != nil {
+			if date, ok := result.Key["h"].(primitive.DateTime); ok {
+				bucket.Key[aggregation.Histogram.Field] = date.Time().UTC().Format(time.RFC3339)
+			} else {
+				bucket.Key[aggregation.Histogram.Field] = nil
+			}
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+func (c MongoCompiler) CompileAnd(expr *AndExpr) (interface{}, error) {
+	conditions, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(conditions) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return conditions[0], nil
+	default:
+		return bson.M{"$and": conditions}, nil
+	}
+}
+
+func (c MongoCompiler) CompileOr(expr *OrExpr) (interface{}, error) {
+	conditions, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{"$or": conditions}, nil
+}
+
+func (c MongoCompiler) CompileNot(expr *NotExpr) (interface{}, error) {
+	conditions, err := c.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{"$nor": conditions}, nil
+}
+
+func (c MongoCompiler) CompileTerms(expr *TermsExpr) (interface{}, error) {
+	return bson.M{c.field(expr.Field): bson.M{"$in": expr.Values}}, nil
+}
+
+// CompileRange needs one element to be within every bound when the field is in an array, like the nested
+// range query of Elasticsearch. Otherwise one product line can match the lower bound and another the upper bound.
+func (c MongoCompiler) CompileRange(expr *RangeExpr) (interface{}, error) {
+	condition := bson.M{}
+	for operator, value := range expr.Bounds() {
+		condition["$"+operator] = value
+	}
+
+	if path := nestedPath(expr.Field); path != "" && c.elemPath == "" {
+		element := MongoCompiler{elemPath: path}
+		return bson.M{mongoField(path): bson.M{"$elemMatch": bson.M{element.field(expr.Field): condition}}}, nil
+	}
+
+	return bson.M{c.field(expr.Field): condition}, nil
+}
+
+// CompileMatch uses case-insensitive regular expressions on word boundaries, so results are comparable
+// with the analyzed text fields of Elasticsearch. Values which are not text are compared as they are.
+func (c MongoCompiler) CompileMatch(expr *MatchExpr) (interface{}, error) {
+	text, isText := expr.Query.(string)
+
+	conditions := make([]bson.M, 0, len(expr.Fields))
+	for _, field := range expr.Fields {
+		if !isText {
+			conditions = append(conditions, bson.M{c.field(field): expr.Query})
+			continue
+		}
+		conditions = append(conditions, bson.M{c.field(field): primitive.Regex{Pattern: textPattern(expr, text), Options: "i"}})
+	}
+
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+
+	// multi_match finds documents where any of the fields matches
+	return bson.M{"$or": conditions}, nil
+}
+
+// CompileElemMatch needs every condition of the filter to match the same element of the array
+func (c MongoCompiler) CompileElemMatch(expr *ElemMatchExpr) (interface{}, error) {
+	condition, err := expr.Filter.Accept(MongoCompiler{elemPath: expr.Path})
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{expr.Path: bson.M{"$elemMatch": condition}}, nil
+}
+
+func (c MongoCompiler) CompileExists(expr *ExistsExpr) (interface{}, error) {
+	return bson.M{c.field(expr.Field): bson.M{"$exists": true, "$ne": nil}}, nil
+}
+
+// field returns the name of the field in MongoDB, relative to the element inside $elemMatch
+func (c MongoCompiler) field(field string) string {
+	if c.elemPath == "" {
+		return mongoField(field)
+	}
+
+	return strings.TrimPrefix(mongoField(field), mongoField(c.elemPath)+".")
+}
+
+func (c MongoCompiler) compileChildren(children []Expr) ([]bson.M, error) {
+	conditions := make([]bson.M, 0, len(children))
+	for _, child := range children {
+		condition, err := child.Accept(c)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition.(bson.M))
+	}
+
+	return conditions, nil
+}
+
+const (
+	wordStart = `(?:^|[^\p{L}\p{N}])`
+	wordEnd   = `(?:$|[^\p{L}\p{N}])`
+	wordChar  = `[\p{L}\p{N}]`
+)
+
+// textPattern creates the regular expression of a match filter. match_phrase needs the words in the given
+// order, other modes need any of the words like the default OR operator of Elasticsearch.
+func textPattern(expr *MatchExpr, text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	if len(words) == 0 {
+		return regexp.QuoteMeta(text)
+	}
+
+	if expr.Mode == MatchModePhrase {
+		quoted := make([]string, 0, len(words))
+		for _, word := range words {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+		return wordStart + strings.Join(quoted, `[^\p{L}\p{N}]+`) + wordEnd
+	}
+
+	alternatives := make([]string, 0, len(words))
+	for _, word := range words {
+		alternatives = append(alternatives, fuzzyAlternatives(word, editDistance(expr.Fuzziness, word))...)
+	}
+
+	return wordStart + "(?:" + strings.Join(alternatives, "|") + ")" + wordEnd
+}
+
+// editDistance returns the allowed edits of a word with the same AUTO rule as Elasticsearch
+func editDistance(fuzziness string, word string) int {
+	switch fuzziness {
+	case "1":
+		return 1
+	case "2":
+		return 2
+	case "AUTO":
+		length := utf8.RuneCountInString(word)
+		if length < 3 {
+			return 0
+		} else if length <= 5 {
+			return 1
+		}
+		return 2
+	default:
+		return 0
+	}
+}
+
+// fuzzyAlternatives lists the word and the patterns of every single edit (substitution, deletion, insertion and
+// transposition). A regular expression cannot count edits, so a distance of 2 is handled as 1 in MongoDB.
+func fuzzyAlternatives(word string, distance int) []string {
+	if distance == 0 {
+		return []string{regexp.QuoteMeta(word)}
+	}
+
+	runes := []rune(word)
+	quote := func(part []rune) string {
+		return regexp.QuoteMeta(string(part))
+	}
+
+	seen := make(map[string]bool)
+	alternatives := make([]string, 0, 4*len(runes)+2)
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			alternatives = append(alternatives, pattern)
+		}
+	}
+
+	add(quote(runes))
+	for i := 0; i <= len(runes); i++ {
+		add(quote(runes[:i]) + wordChar + quote(runes[i:]))
+		if i == len(runes) {
+			continue
+		}
+		add(quote(runes[:i]) + wordChar + quote(runes[i+1:]))
+		if len(runes) > 1 {
+			add(quote(runes[:i]) + quote(runes[i+1:]))
+		}
+		if i+1 < len(runes) {
+			add(quote(runes[:i]) + quote(runes[i+1:i+2]) + quote(runes[i:i+1]) + quote(runes[i+2:]))
+		}
+	}
+
+	return alternatives
+}
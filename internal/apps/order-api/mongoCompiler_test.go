@@ -0,0 +1,224 @@
+package order_api
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMongoSortAddsMissingFlagForEveryField(t *testing.T) {
+	tests := []struct {
+		name          string
+		clause        SortClause
+		flagDirection int
+	}{
+		{"asc missing last", SortClause{Field: "city"}, 1},
+		{"asc missing first", SortClause{Field: "city", MissingFirst: true}, -1},
+		{"desc missing last", SortClause{Field: "city", Descending: true}, 1},
+		{"desc missing first", SortClause{Field: "city", Descending: true, MissingFirst: true}, -1},
+	}
+
+	var compiler MongoCompiler
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orderBy := compiler.Sort(&Query{Sort: []SortClause{test.clause}})
+
+			if len(orderBy) != 3 {
+				t.Fatalf("expected flag, field and _id keys, got %v", orderBy)
+			}
+			if orderBy[0].Key != "_missing0" || orderBy[0].Direction != test.flagDirection || orderBy[0].Value == nil {
+				t.Errorf("unexpected flag key %+v", orderBy[0])
+			}
+			if orderBy[1].Key != "city" || orderBy[1].Value != nil {
+				t.Errorf("unexpected field key %+v", orderBy[1])
+			}
+			if orderBy[2].Key != "_id" {
+				t.Errorf("expected _id as tie-breaker, got %+v", orderBy[2])
+			}
+		})
+	}
+}
+
+func TestMongoSortReducesArrayFields(t *testing.T) {
+	tests := []struct {
+		descending bool
+		reduce     string
+	}{
+		{false, "$min"},
+		{true, "$max"},
+	}
+
+	var compiler MongoCompiler
+
+	for _, test := range tests {
+		orderBy := compiler.Sort(&Query{Sort: []SortClause{{Field: "product.price", Descending: test.descending}}})
+
+		key := orderBy[1]
+		if key.Key != "_sort0" {
+			t.Fatalf("expected a computed key for product.price, got %+v", key)
+		}
+		if !reflect.DeepEqual(key.Value, bson.M{test.reduce: "$product.price"}) {
+			t.Errorf("expected %s of product.price, got %v", test.reduce, key.Value)
+		}
+	}
+}
+
+func TestMongoSearchAfterSkipsMissingValues(t *testing.T) {
+	var compiler MongoCompiler
+
+	// The last order of the page has no city, so the cursor continues with the orders without city and
+	// then with the orders which have a city
+	query := &Query{
+		Sort:  []SortClause{{Field: "city", MissingFirst: true}},
+		After: []interface{}{int32(1), nil, "order-1"},
+	}
+
+	searchAfter, err := compiler.SearchAfter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bson.M{"$or": []bson.M{
+		{"_missing0": bson.M{"$lt": int32(1)}},
+		{"_missing0": int32(1), "city": nil, "_id": bson.M{"$gt": "order-1"}},
+	}}
+	if !reflect.DeepEqual(searchAfter, expected) {
+		t.Errorf("expected %v, got %v", expected, searchAfter)
+	}
+}
+
+func TestMongoSortValues(t *testing.T) {
+	var compiler MongoCompiler
+
+	query := &Query{Sort: []SortClause{{Field: "product.price"}, {Field: "city"}}}
+
+	// Computed keys are added by the pipeline
+	document, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: "order-1"},
+		{Key: "product", Value: bson.A{bson.M{"price": 20.0}, bson.M{"price": 5.0}}},
+		{Key: "_missing0", Value: int32(0)},
+		{Key: "_sort0", Value: 5.0},
+		{Key: "_missing1", Value: int32(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := compiler.SortValues(query, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{int32(0), 5.0, int32(1), nil, "order-1"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		fuzziness string
+		word      string
+		distance  int
+	}{
+		{"", "ankara", 0},
+		{"0", "ankara", 0},
+		{"1", "an", 1},
+		{"2", "an", 2},
+		// AUTO allows no edit below 3 characters, one up to 5 and two above
+		{"AUTO", "an", 0},
+		{"AUTO", "ank", 1},
+		{"AUTO", "izmir", 1},
+		{"AUTO", "ankara", 2},
+		{"AUTO", "çeşme", 1},
+	}
+
+	for _, test := range tests {
+		if distance := editDistance(test.fuzziness, test.word); distance != test.distance {
+			t.Errorf("%s %q: expected %d, got %d", test.fuzziness, test.word, test.distance, distance)
+		}
+	}
+}
+
+func TestFuzzyAlternatives(t *testing.T) {
+	if alternatives := fuzzyAlternatives("a.b", 0); !reflect.DeepEqual(alternatives, []string{`a\.b`}) {
+		t.Errorf("expected the quoted word without edits, got %v", alternatives)
+	}
+
+	// One alternative per edit, duplicates like the insertions before and after the same letter are left out
+	alternatives := fuzzyAlternatives("ab", 1)
+	seen := make(map[string]bool)
+	for _, alternative := range alternatives {
+		if seen[alternative] {
+			t.Errorf("%q is listed twice", alternative)
+		}
+		seen[alternative] = true
+	}
+	if !seen["ab"] || !seen["ba"] || !seen["a"] || !seen["b"] {
+		t.Errorf("expected the word, the transposition and the deletions in %v", alternatives)
+	}
+}
+
+func TestTextPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    MatchExpr
+		text    string
+		matches []string
+		misses  []string
+	}{
+		{
+			name:    "any of the words",
+			expr:    MatchExpr{Mode: MatchModeMatch},
+			text:    "new york",
+			matches: []string{"New York", "York", "new jersey", "Old York City"},
+			misses:  []string{"Yorkshire", "renew", ""},
+		},
+		{
+			name:    "phrase",
+			expr:    MatchExpr{Mode: MatchModePhrase},
+			text:    "new york",
+			matches: []string{"New York", "new-york", "in New  York city"},
+			misses:  []string{"York New", "New", "Newyork"},
+		},
+		{
+			name:    "one edit",
+			expr:    MatchExpr{Mode: MatchModeFuzzy, Fuzziness: "1"},
+			text:    "izmir",
+			matches: []string{"Izmir", "Izmr", "Izmiir", "Izmer", "Imzir"},
+			misses:  []string{"Izm", "Ankara"},
+		},
+		{
+			name:    "non-latin letters are word characters",
+			expr:    MatchExpr{Mode: MatchModeMatch},
+			text:    "çeşme",
+			matches: []string{"Çeşme", "Alaçatı, Çeşme"},
+			misses:  []string{"Çeşmeli"},
+		},
+		{
+			name:    "special characters are quoted",
+			expr:    MatchExpr{Mode: MatchModeMatch},
+			text:    "?*",
+			matches: []string{"what?*"},
+			misses:  []string{"what"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattern := regexp.MustCompile("(?i)" + textPattern(&test.expr, test.text))
+			for _, text := range test.matches {
+				if !pattern.MatchString(text) {
+					t.Errorf("expected %q to match %s", text, pattern)
+				}
+			}
+			for _, text := range test.misses {
+				if pattern.MatchString(text) {
+					t.Errorf("expected %q not to match %s", text, pattern)
+				}
+			}
+		})
+	}
+}
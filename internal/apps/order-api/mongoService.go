@@ -3,21 +3,27 @@ package order_api
 import (
 	"GenericEndpoint/internal/models"
 	"GenericEndpoint/internal/repository"
+	"GenericEndpoint/pkg"
+	"errors"
+	"fmt"
+	"github.com/labstack/gommon/log"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
 )
 
 type MongoService struct {
-	Repository *repository.Repository
+	Repository      *repository.Repository
+	IdempotencyKeys *repository.IdempotencyRepository
 }
 
-func NewService(Repository *repository.Repository) *MongoService {
-	service := &MongoService{Repository: Repository}
+func NewService(Repository *repository.Repository, IdempotencyKeys *repository.IdempotencyRepository) *MongoService {
+	service := &MongoService{Repository: Repository, IdempotencyKeys: IdempotencyKeys}
 	return service
 }
 
-func (s *MongoService) GetAll() ([]models.Order, error) {
-	result, err := s.Repository.GetAll()
+func (s *MongoService) GetAll(includeDeleted bool) ([]models.Order, error) {
+	result, err := s.Repository.GetAll(includeDeleted)
 
 	if err != nil {
 		return nil, err
@@ -26,87 +32,488 @@ func (s *MongoService) GetAll() ([]models.Order, error) {
 	return result, nil
 }
 
-func (s *MongoService) GetOrdersWithFilter(filter bson.M, findOptions *options.FindOptions) ([]models.Order, error) {
-	result, err := s.Repository.GetOrdersWithFilter(filter, findOptions)
+// GetByID returns mongo.ErrNoDocuments for soft deleted orders too
+func (s *MongoService) GetByID(id string) (models.Order, error) {
+	result, err := s.Repository.FindByID(id)
 
 	if err != nil {
-		return nil, err
+		return models.Order{}, err
+	}
+
+	if result.IsDeleted() {
+		return models.Order{}, mongo.ErrNoDocuments
 	}
 
 	return result, nil
 }
 
-func (s *MongoService) Insert(order models.Order) (models.Order, error) {
-	_, err := s.Repository.Insert(order)
+// GetDeleted returns one page of the soft deleted orders like GetOrdersPage, the last deleted order is the first
+func (s *MongoService) GetDeleted(limit int, offset int, cursor string) ([]models.Order, int64, string, error) {
+	query, err := ParseOrderGetRequest(OrderGetRequest{
+		OrderFilter: OrderFilter{IncludeDeleted: true},
+		Sort:        []SortRequest{{Field: "deletedAt", Direction: "desc"}},
+		Limit:       limit,
+		Offset:      offset,
+		Cursor:      cursor,
+	})
+	if err != nil {
+		return nil, 0, "", err
+	}
+	query.Filter = &AndExpr{Children: []Expr{query.Filter, &ExistsExpr{Field: "deletedAt"}}}
 
+	return s.getPage(query)
+}
+
+// Restore removes deletedAt of the order, mongo.ErrNoDocuments is returned if the order is not soft deleted
+func (s *MongoService) Restore(id string, actor string) (models.Order, error) {
+	order, err := s.Repository.FindByID(id)
 	if err != nil {
 		return models.Order{}, err
 	}
 
+	if !order.IsDeleted() {
+		return models.Order{}, mongo.ErrNoDocuments
+	}
+
+	if _, err := s.Repository.Restore(id, actor); err != nil {
+		return models.Order{}, err
+	}
+
+	order.DeletedAt = nil
+	order.Version++
 	return order, nil
 }
 
-func (s *MongoService) Delete(id string) (bool, error) {
-	result, err := s.Repository.Delete(id)
+func (s *MongoService) Insert(order models.Order, actor string) (models.Order, error) {
+	_, err := s.Repository.Insert(order, actor)
 
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	return order, nil
+}
+
+// InsertWithIdempotencyKey inserts the order unless the key is used before, then the id of the order created
+// with the key is returned. A key used with another request body, or by a request which is still in progress,
+// is returned as ConflictError.
+func (s *MongoService) InsertWithIdempotencyKey(order models.Order, key string, requestHash string, actor string) (string, error) {
+	existing, err := s.IdempotencyKeys.Reserve(models.NewIdempotencyKey(key, requestHash, order.ID))
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		action, err := resolveIdempotencyKey(*existing, requestHash, time.Now())
+		if err != nil {
+			return "", err
+		}
+		if action == idempotencyReplay {
+			return existing.OrderID, nil
+		}
+
+		// The request which reserved the key stopped, its order can be inserted without the key being completed
+		if _, err := s.Repository.FindByID(existing.OrderID); err == nil {
+			if err := s.IdempotencyKeys.Complete(key); err != nil {
+				return "", err
+			}
+			return existing.OrderID, nil
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", err
+		}
+
+		taken, err := s.IdempotencyKeys.TakeOver(*existing, order.ID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return "", idempotencyInProgress(key)
+		}
+	}
+
+	if _, err := s.Insert(order, actor); err != nil {
+		// The order is not created, so a retry with the key creates it
+		if deleteErr := s.IdempotencyKeys.Delete(key); deleteErr != nil {
+			return "", fmt.Errorf("%v (idempotency key {%v} could not be released: %v)", err, key, deleteErr)
+		}
+		return "", err
+	}
+
+	// The order is created, a key which stays in progress is completed by a retry after the lease
+	if err := s.IdempotencyKeys.Complete(key); err != nil {
+		log.Errorf("Idempotency key {%v} of order {%v} could not be completed: %v", key, order.ID, err)
+	}
+
+	return order.ID, nil
+}
+
+// idempotencyAction is what a request does with a key which is saved by another request
+type idempotencyAction int
+
+const (
+	// The order of the key is created, its id is returned
+	idempotencyReplay idempotencyAction = iota
+	// The request which reserved the key did not finish within the lease, the key is taken over
+	idempotencyTakeOver
+)
+
+// resolveIdempotencyKey decides what a request with the hash does with the saved key. Keys of other requests
+// and keys whose order may still be inserted are returned as ConflictError.
+func resolveIdempotencyKey(existing models.IdempotencyKey, requestHash string, now time.Time) (idempotencyAction, error) {
+	if existing.RequestHash != requestHash {
+		return 0, &pkg.ConflictError{Message: fmt.Sprintf("Idempotency key {%v} is used with another request.", existing.Key)}
+	}
+
+	if existing.IsCompleted() {
+		return idempotencyReplay, nil
+	}
+
+	if now.Sub(existing.CreatedAt) < IdempotencyLease {
+		return 0, idempotencyInProgress(existing.Key)
+	}
+
+	return idempotencyTakeOver, nil
+}
+
+func idempotencyInProgress(key string) error {
+	return &pkg.ConflictError{Message: fmt.Sprintf("Request with idempotency key {%v} is in progress, it can be retried later.", key)}
+}
+
+// InsertMany inserts the orders with their outbox events, the reasons of failed orders are returned by their index
+func (s *MongoService) InsertMany(orders []models.Order, ordered bool, actor string) (map[int]string, error) {
+	return s.Repository.InsertMany(orders, ordered, actor)
+}
+
+// Replace saves the order if the version is its current version, the order is saved with the next version
+func (s *MongoService) Replace(order models.Order, version int64, actor string) (models.Order, error) {
+	if err := checkVersion(order, version); err != nil {
+		return models.Order{}, err
+	}
+	order.Version = version + 1
+
+	_, err := s.Repository.Replace(order, version, actor)
+
+	if err != nil {
+		return models.Order{}, versionError(order.ID, err)
+	}
+
+	return order, nil
+}
+
+// Patch sets the fields of the patch request which are not nil, total and updatedAt are recalculated
+func (s *MongoService) Patch(id string, req OrderPatchRequest, version int64, actor string) (models.Order, error) {
+	if err := Validate(req); err != nil {
+		return models.Order{}, err
+	}
+
+	order, err := s.GetByID(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	if err := checkVersion(order, version); err != nil {
+		return models.Order{}, err
+	}
+
+	fields := bson.M{}
+	if req.UserID != nil {
+		order.UserID = *req.UserID
+		fields["userId"] = order.UserID
+	}
+	if req.Status != nil && *req.Status != order.Status {
+		if err := order.ChangeStatus(*req.Status, time.Now()); err != nil {
+			return models.Order{}, &pkg.BadRequestError{Message: err.Error()}
+		}
+		fields["status"] = order.Status
+		fields["statusHistory"] = order.StatusHistory
+	}
+	if req.City != nil {
+		order.City = *req.City
+		fields["city"] = order.City
+	}
+	if req.AddressDetail != nil {
+		order.AddressDetail = *req.AddressDetail
+		fields["addressDetail"] = order.AddressDetail
+	}
+	if req.Product != nil {
+		order.Product = ProductLines(*req.Product)
+		fields["product"] = order.Product
+	}
+
+	order.CalculateTotal()
+	order.UpdatedAt = time.Now()
+	fields["total"] = order.Total
+	fields["updatedAt"] = order.UpdatedAt
+
+	if _, err := s.Repository.Update(id, fields, version, models.AuditActionUpdate, actor); err != nil {
+		return models.Order{}, versionError(id, err)
+	}
+
+	order.Version++
+	return order, nil
+}
+
+// ChangeStatus moves the order to the status, illegal transitions are returned as BadRequestError
+func (s *MongoService) ChangeStatus(id string, status string, version int64, actor string) (models.Order, error) {
+	order, err := s.GetByID(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	if err := checkVersion(order, version); err != nil {
+		return models.Order{}, err
+	}
+
+	now := time.Now()
+	if err := order.ChangeStatus(status, now); err != nil {
+		return models.Order{}, &pkg.BadRequestError{Message: err.Error()}
+	}
+	order.UpdatedAt = now
+
+	fields := bson.M{
+		"status":        order.Status,
+		"statusHistory": order.StatusHistory,
+		"updatedAt":     order.UpdatedAt,
+	}
+
+	if _, err := s.Repository.Update(id, fields, version, models.AuditActionStatusChange, actor); err != nil {
+		return models.Order{}, versionError(id, err)
+	}
+
+	order.Version++
+	return order, nil
+}
+
+// Delete soft deletes the order if the version is its current version
+func (s *MongoService) Delete(id string, version int64, actor string) (bool, error) {
+	order, err := s.GetByID(id)
 	if err != nil {
 		return false, err
 	}
 
+	if err := checkVersion(order, version); err != nil {
+		return false, err
+	}
+
+	result, err := s.Repository.Delete(id, version, actor)
+
+	if err != nil {
+		return false, versionError(id, err)
+	}
+
 	return result, nil
 }
 
-func (s *MongoService) FromModelConvertToFilter(req OrderGetRequest) (bson.M, *options.FindOptions) {
+// checkVersion returns PreconditionFailedError if the version is not the current version of the order
+func checkVersion(order models.Order, version int64) error {
+	if order.Version != version {
+		return &pkg.PreconditionFailedError{
+			Message: fmt.Sprintf("Order {%v} has version %d, not %d.", order.ID, order.Version, version),
+		}
+	}
 
-	// Create a filter based on the exact filters and matches provided in the request
-	filter := bson.M{}
+	return nil
+}
 
-	// Add exact filter criteria to filter if provided
-	if len(req.ExactFilters) > 0 {
-		for key, values := range req.ExactFilters {
-			filter[key] = bson.M{"$in": values}
+// versionError converts the conflict of an order changed by another request after it is read
+// to PreconditionFailedError, other errors are returned as they are
+func versionError(id string, err error) error {
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return &pkg.PreconditionFailedError{
+			Message: fmt.Sprintf("Order {%v} is changed by another request.", id),
 		}
 	}
 
-	// Add match criteria to filter if provided
-	if len(req.Match) > 0 {
-		match := bson.M{}
-		for key, value := range req.Match {
-			match[key] = value
-		}
-		filter = bson.M{
-			"$and": []bson.M{
-				filter,
-				match,
-			},
+	return err
+}
+
+// FindOrdersToDelete returns the ids of the orders matching a delete by query request and the compiled filter,
+// which is applied again when they are deleted. An empty filter and more matching orders than the limit are
+// rejected, so a mistake cannot delete every order.
+func (s *MongoService) FindOrdersToDelete(req OrderDeleteByQueryRequest) ([]string, bson.M, error) {
+	maxDocuments := req.MaxDocuments
+	if maxDocuments == 0 {
+		maxDocuments = MaxDeleteByQuery
+	}
+	if maxDocuments < 0 || maxDocuments > MaxDeleteByQuery {
+		return nil, nil, &pkg.BadRequestError{Message: fmt.Sprintf("max_documents must be between 1 and %d", MaxDeleteByQuery)}
+	}
+
+	filterExpr, err := parseFilter(req.OrderFilter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var compiler MongoCompiler
+
+	// The compiled filter is checked, a request with only empty conditions would match every order
+	filter, err := compiler.Filter(&Query{Filter: filterExpr})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(filter) == 0 {
+		return nil, nil, &pkg.BadRequestError{Message: "delete by query needs a filter"}
+	}
+
+	excludeDeleted(filterExpr, req.IncludeDeleted)
+	if filter, err = compiler.Filter(&Query{Filter: filterExpr}); err != nil {
+		return nil, nil, err
+	}
+
+	count, err := s.Repository.CountOrdersWithFilter(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > int64(maxDocuments) {
+		return nil, nil, &pkg.BadRequestError{Message: fmt.Sprintf("%d orders match the filter, more than the limit of %d", count, maxDocuments)}
+	}
+
+	orders, err := s.Repository.GetOrdersAfterID(filter, "", int64(maxDocuments))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+
+	return ids, filter, nil
+}
+
+// SoftDeleteMany soft deletes the orders with the ids which still match the filter, an order changed since it was
+// found is left out. The number of deleted orders and the orders to index are returned, deleted orders are
+// restored like other deleted orders and removed by the purge job after the retention.
+func (s *MongoService) SoftDeleteMany(ids []string, filter bson.M, actor string) (int64, []models.Order, error) {
+	deleted, err := s.Repository.SoftDeleteMany(ids, filter, models.AuditActionDeleteByQuery, actor)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	orders, err := s.Repository.GetOrdersWithFilter(bson.M{"_id": bson.M{"$in": ids}, "deletedAt": bson.M{"$ne": nil}}, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return deleted, orders, nil
+}
+
+// GetHistory lists the audit entries of the order with the total number of entries, the last change is the first
+func (s *MongoService) GetHistory(id string, limit int, offset int) ([]models.AuditEntry, int64, error) {
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return nil, 0, &pkg.BadRequestError{Message: fmt.Sprintf("limit must be between 1 and %d", MaxPageSize)}
+	}
+	if offset < 0 {
+		return nil, 0, &pkg.BadRequestError{Message: "offset cannot be negative"}
+	}
+
+	// Deleted orders have a history too, so only the audit entries are searched
+	entries, total, err := s.Repository.GetAuditEntries(id, int64(limit), int64(offset))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Orders written before the audit trail have no entries, they have an empty history. FindByID finds soft
+	// deleted orders too and returns mongo.ErrNoDocuments for unknown orders.
+	if total == 0 {
+		if _, err := s.Repository.FindByID(id); err != nil {
+			return nil, 0, err
 		}
+		return []models.AuditEntry{}, 0, nil
+	}
+
+	return entries, total, nil
+}
+
+// GetOrdersPage returns one page of orders for the generic query, the total count of matching orders and
+// the cursor of the next page (empty on the last page)
+func (s *MongoService) GetOrdersPage(req OrderGetRequest) ([]models.Order, int64, string, error) {
+	query, err := ParseOrderGetRequest(req)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	return s.getPage(query)
+}
+
+func (s *MongoService) getPage(query *Query) ([]models.Order, int64, string, error) {
+	var compiler MongoCompiler
+
+	filter, err := compiler.Filter(query)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	total, err := s.Repository.CountOrdersWithFilter(filter)
+	if err != nil {
+		return nil, 0, "", err
 	}
 
-	// Create options for the find operation, including the requested fields and sort order
-	findOptions := options.Find()
+	pipeline, err := compiler.Pipeline(query, filter)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	documents, err := s.Repository.AggregateOrders(pipeline)
+	if err != nil {
+		return nil, 0, "", err
+	}
 
-	// Add projection criteria to find options if provided
-	if len(req.Fields) > 0 {
-		projection := bson.M{}
-		findOptions.SetProjection(projection)
-		for _, field := range req.Fields {
-			projection[field] = 1
+	orders := make([]models.Order, 0, len(documents))
+	for _, document := range documents {
+		var order models.Order
+		if err := bson.Unmarshal(document, &order); err != nil {
+			return nil, 0, "", err
+		}
+		if len(query.Lines) > 0 {
+			if err := MatchingLines(&order, query.Lines); err != nil {
+				return nil, 0, "", err
+			}
 		}
+		orders = append(orders, order)
 	}
 
-	// Add sort criteria to find options if provided
-	if len(req.Sort) > 0 {
-		sort := bson.M{}
-		for key, value := range req.Sort {
-			sort[key] = value
+	// Sort values are read from the stored document, missing fields would be zero values in models.Order
+	var nextCursor string
+	if len(documents) == query.Limit {
+		values, err := compiler.SortValues(query, documents[len(documents)-1])
+		if err != nil {
+			return nil, 0, "", err
 		}
-		findOptions.SetSort(sort)
+
+		nextCursor, err = encodeCursor(values)
+		if err != nil {
+			return nil, 0, "", err
+		}
+	}
+
+	return orders, total, nextCursor, nil
+}
+
+// Aggregate groups the filtered orders and calculates the metrics of every group
+func (s *MongoService) Aggregate(req OrderAggregateRequest) ([]AggregateBucket, error) {
+	aggregation, err := ParseOrderAggregateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var compiler MongoCompiler
+
+	filter, err := compiler.Filter(&Query{Filter: aggregation.Filter})
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := s.Repository.AggregateOrders(compiler.AggregationPipeline(aggregation, filter))
+	if err != nil {
+		return nil, err
 	}
 
-	return filter, findOptions
+	return compiler.AggregateBuckets(aggregation, documents)
 }
 
 // TODO: MongoDB ile response dönerken interface döndüğümde key-value olarak yazıyor model döndüğümde ise gereksiz olarak tüm fieldları dönüyor.
 // TODO: ID değeri elasticsearch tarafında girilmeyince geri dönmüyor bence dönülmeli
-
-// TODO: Elasticsearch match
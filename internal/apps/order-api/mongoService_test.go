@@ -0,0 +1,100 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/pkg"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFindOrdersToDeleteRejectsEmptyFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no filter", `{}`},
+		{"empty query group", `{"query": {}}`},
+		{"nested empty groups", `{"query": {"and": [{}, {"and": [{}]}]}}`},
+		{"or with an empty group", `{"query": {"or": [{}, {"exact_filters": {"city": ["Ankara"]}}]}}`},
+		{"exact filter without values", `{"exact_filters": {"status": []}}`},
+		{"range without bounds", `{"range": {"total": {}}}`},
+	}
+
+	// The filter is rejected before the repository is used
+	service := &MongoService{}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req OrderDeleteByQueryRequest
+			if err := json.Unmarshal([]byte(test.body), &req); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := service.FindOrdersToDelete(req)
+
+			var badRequestError *pkg.BadRequestError
+			if !errors.As(err, &badRequestError) || badRequestError.Message != "delete by query needs a filter" {
+				t.Fatalf("expected the empty filter to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestResolveIdempotencyKey(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		existing models.IdempotencyKey
+		hash     string
+		action   idempotencyAction
+		conflict bool
+	}{
+		{
+			name:     "duplicate of a completed request",
+			existing: models.IdempotencyKey{Key: "key", RequestHash: "hash", Status: models.IdempotencyStatusCompleted, CreatedAt: now},
+			hash:     "hash",
+			action:   idempotencyReplay,
+		},
+		{
+			name:     "key saved before statuses",
+			existing: models.IdempotencyKey{Key: "key", RequestHash: "hash", CreatedAt: now.Add(-time.Hour)},
+			hash:     "hash",
+			action:   idempotencyReplay,
+		},
+		{
+			name:     "another request body",
+			existing: models.IdempotencyKey{Key: "key", RequestHash: "hash", Status: models.IdempotencyStatusCompleted, CreatedAt: now},
+			hash:     "other",
+			conflict: true,
+		},
+		{
+			name:     "first request in flight",
+			existing: models.IdempotencyKey{Key: "key", RequestHash: "hash", Status: models.IdempotencyStatusInProgress, CreatedAt: now.Add(-time.Second)},
+			hash:     "hash",
+			conflict: true,
+		},
+		{
+			name:     "first request stopped and the lease ended",
+			existing: models.IdempotencyKey{Key: "key", RequestHash: "hash", Status: models.IdempotencyStatusInProgress, CreatedAt: now.Add(-2 * IdempotencyLease)},
+			hash:     "hash",
+			action:   idempotencyTakeOver,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action, err := resolveIdempotencyKey(test.existing, test.hash, now)
+
+			var conflictError *pkg.ConflictError
+			if test.conflict != errors.As(err, &conflictError) {
+				t.Fatalf("expected conflict %v, got %v", test.conflict, err)
+			}
+			if !test.conflict && action != test.action {
+				t.Errorf("expected action %v, got %v", test.action, action)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// MaxBulkOrders limits the number of orders in a bulk create request
+const MaxBulkOrders = 1000
+
+// MaxDeleteByQuery limits the number of orders deleted by one delete by query request
+const MaxDeleteByQuery = 1000
+
+// MaxIdempotencyKeyLength limits the length of the Idempotency-Key header
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyLease is how long a key belongs to the request which reserved it, the insert of the order times out
+// before it. A retry after the lease takes over a key whose order is not created.
+const IdempotencyLease = time.Minute
+
+// HashRequest returns the hash of the bound request, so a retried request matches whatever the formatting
+// and the order of the fields in its body
+func HashRequest(req interface{}) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// ProductLines converts the product lines of a request to the product lines of an order
+func ProductLines(lines []ProductLineRequest) []models.ProductLine {
+	productLines := make([]models.ProductLine, 0, len(lines))
+	for _, line := range lines {
+		productLines = append(productLines, models.ProductLine(line))
+	}
+
+	return productLines
+}
+
+// NewOrder creates a new order from the request with a new id, the status is always created
+func NewOrder(req OrderCreateRequest, now time.Time) (models.Order, error) {
+	if err := Validate(req); err != nil {
+		return models.Order{}, err
+	}
+
+	var orderModel models.Order
+
+	orderModel.UserID = req.UserID
+	orderModel.City = req.City
+	orderModel.AddressDetail = req.AddressDetail
+	orderModel.Product = ProductLines(req.Product)
+
+	// Create id and created date value
+	orderModel.ID = uuid.New().String()
+	orderModel.CreatedAt = now
+	// We don't want to set null, so we put CreatedAt value.
+	orderModel.UpdatedAt = orderModel.CreatedAt
+	orderModel.Version = 1
+
+	// Other statuses are reached with the status endpoint, so every order goes through the allowed transitions
+	orderModel.Status = models.StatusCreated
+	orderModel.StatusHistory = map[string]time.Time{models.StatusCreated: orderModel.CreatedAt}
+
+	orderModel.CalculateTotal()
+
+	return orderModel, nil
+}
@@ -0,0 +1,114 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/internal/repository"
+	"context"
+	"errors"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// OutboxDispatcher applies pending outbox events to Elasticsearch in the background. Every event syncs the
+// current state of its order (index if it exists, delete otherwise), so events can be retried in any order.
+type OutboxDispatcher struct {
+	Repository     *repository.Repository
+	ElasticService *ElasticService
+	Interval       time.Duration
+	BatchSize      int64
+	MaxAttempts    int
+}
+
+func NewOutboxDispatcher(repository *repository.Repository, elasticService *ElasticService) *OutboxDispatcher {
+	dispatcher := &OutboxDispatcher{
+		Repository:     repository,
+		ElasticService: elasticService,
+		Interval:       time.Second,
+		BatchSize:      100,
+		MaxAttempts:    10,
+	}
+	return dispatcher
+}
+
+// Start dispatches pending events every interval until the context is cancelled
+func (d *OutboxDispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Outbox dispatcher is stopped")
+			return
+		case <-ticker.C:
+			// A full batch means more events are waiting
+			for {
+				count, err := d.DispatchPending()
+				if err != nil {
+					log.Errorf("Error dispatching outbox events: %v", err)
+				}
+				if err != nil || int64(count) < d.BatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DispatchPending applies one batch of pending events and returns the number of events in the batch
+func (d *OutboxDispatcher) DispatchPending() (int, error) {
+	events, err := d.Repository.GetPendingOutboxEvents(d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		if err := d.apply(event); err != nil {
+			d.retry(event, err)
+			continue
+		}
+
+		if err := d.Repository.DeleteOutboxEvent(event.ID); err != nil {
+			log.Errorf("Error deleting outbox event {%v}: %v", event.ID, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+func (d *OutboxDispatcher) apply(event models.OutboxEvent) error {
+	order, err := d.Repository.FindByID(event.OrderID)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return d.ElasticService.DeleteOrderFromElasticsearch(event.OrderID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return d.ElasticService.SaveOrderToElasticsearch(order)
+}
+
+// retry schedules the event again with exponential backoff, it is marked as failed after MaxAttempts
+func (d *OutboxDispatcher) retry(event models.OutboxEvent, cause error) {
+	event.Attempts++
+	event.LastError = cause.Error()
+
+	if event.Attempts >= d.MaxAttempts {
+		event.Status = models.OutboxStatusFailed
+		log.Errorf("Outbox event {%v} of order {%v} failed after %d attempts: %v", event.ID, event.OrderID, event.Attempts, cause)
+	} else {
+		backoff := time.Duration(1<<uint(event.Attempts)) * time.Second
+		if backoff > 5*time.Minute {
+			backoff = 5 * time.Minute
+		}
+		event.NextAttemptAt = time.Now().Add(backoff)
+		log.Warnf("Outbox event {%v} of order {%v} will be retried in %v: %v", event.ID, event.OrderID, backoff, cause)
+	}
+
+	if err := d.Repository.RetryOutboxEvent(event); err != nil {
+		log.Errorf("Error saving outbox event {%v}: %v", event.ID, err)
+	}
+}
@@ -0,0 +1,64 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"encoding/base64"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// cursorPayload is the content of the opaque continuation cursor. It keeps the sort values of the last item
+// of a page, MongoDB uses them for a range condition and Elasticsearch passes them as search_after.
+type cursorPayload struct {
+	Values []interface{} `bson:"values"`
+}
+
+// PageSize validates limit, offset and cursor of the request and returns the size of the page to fetch
+func (r OrderGetRequest) PageSize() (int, error) {
+	if r.Limit < 0 || r.Limit > MaxPageSize {
+		return 0, &pkg.BadRequestError{Message: fmt.Sprintf("limit must be between 0 and %d", MaxPageSize)}
+	}
+
+	if r.Offset < 0 {
+		return 0, &pkg.BadRequestError{Message: "offset cannot be negative"}
+	}
+
+	if r.Offset > 0 && r.Cursor != "" {
+		return 0, &pkg.BadRequestError{Message: "offset and cursor cannot be used together"}
+	}
+
+	if r.Limit == 0 {
+		return DefaultPageSize, nil
+	}
+
+	return r.Limit, nil
+}
+
+// encodeCursor converts sort values to an opaque cursor. Extended JSON keeps the BSON types (dates etc.) of the values.
+func encodeCursor(values []interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(cursorPayload{Values: values}, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, &pkg.BadRequestError{Message: "cursor is not valid"}
+	}
+
+	var payload cursorPayload
+	if err := bson.UnmarshalExtJSON(data, true, &payload); err != nil {
+		return nil, &pkg.BadRequestError{Message: "cursor is not valid"}
+	}
+
+	return payload.Values, nil
+}
@@ -0,0 +1,82 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCursorKeepsTheTypesOfSortValues(t *testing.T) {
+	date := primitive.NewDateTimeFromTime(time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC))
+
+	tests := []struct {
+		name   string
+		values []interface{}
+	}{
+		{"text", []interface{}{"Ankara", "order-1"}},
+		{"numbers", []interface{}{int32(1), int64(2), 2.5, "order-1"}},
+		{"date", []interface{}{date, "order-1"}},
+		{"missing value", []interface{}{int32(1), nil, "order-1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor, err := encodeCursor(test.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values, err := decodeCursor(cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("expected %#v, got %#v", test.values, values)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsInvalidCursors(t *testing.T) {
+	// The second cursor is "not json" in base64
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24"} {
+		_, err := decodeCursor(cursor)
+
+		var badRequestError *pkg.BadRequestError
+		if !errors.As(err, &badRequestError) {
+			t.Errorf("%q: expected a bad request, got %v", cursor, err)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      OrderGetRequest
+		pageSize int
+		valid    bool
+	}{
+		{"default", OrderGetRequest{}, DefaultPageSize, true},
+		{"limit", OrderGetRequest{Limit: 10}, 10, true},
+		{"maximum", OrderGetRequest{Limit: MaxPageSize}, MaxPageSize, true},
+		{"over the maximum", OrderGetRequest{Limit: MaxPageSize + 1}, 0, false},
+		{"negative limit", OrderGetRequest{Limit: -1}, 0, false},
+		{"negative offset", OrderGetRequest{Offset: -1}, 0, false},
+		{"offset and cursor", OrderGetRequest{Offset: 1, Cursor: "e30"}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pageSize, err := test.req.PageSize()
+			if test.valid != (err == nil) {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+			if pageSize != test.pageSize {
+				t.Errorf("expected %d, got %d", test.pageSize, pageSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linePredicate reports whether a product line matches, the line has the fields name, quantity and price
+type linePredicate func(line map[string]interface{}) bool
+
+// lineMatcher compiles the filter of a product line filter to a linePredicate. MongoDB does not return which
+// elements matched $elemMatch, so the matching lines are found again on the returned orders with the same rules.
+type lineMatcher struct {
+	path string
+}
+
+// MatchingLines keeps the product lines matching any of the product line filters
+func MatchingLines(order *models.Order, lines []*ElemMatchExpr) error {
+	predicates := make([]linePredicate, 0, len(lines))
+	for _, elemMatch := range lines {
+		predicate, err := elemMatch.Filter.Accept(lineMatcher{path: elemMatch.Path})
+		if err != nil {
+			return err
+		}
+		predicates = append(predicates, predicate.(linePredicate))
+	}
+
+	matching := order.Product[:0]
+	for _, product := range order.Product {
+		line := map[string]interface{}{"name": product.Name, "quantity": product.Quantity, "price": product.Price}
+		for _, predicate := range predicates {
+			if predicate(line) {
+				matching = append(matching, product)
+				break
+			}
+		}
+	}
+	order.Product = matching
+
+	return nil
+}
+
+// matchingLinesFromHits returns the nested objects of the inner hits in their order in the document,
+// an object matching more than one filter is returned once
+func matchingLinesFromHits(innerHits map[string]interface{}) []interface{} {
+	lines := make(map[int]interface{})
+	for _, innerHit := range innerHits {
+		hitsBody, _ := innerHit.(map[string]interface{})["hits"].(map[string]interface{})
+		hits, _ := hitsBody["hits"].([]interface{})
+		for _, hit := range hits {
+			hitMap, _ := hit.(map[string]interface{})
+			nested, _ := hitMap["_nested"].(map[string]interface{})
+			offset, _ := nested["offset"].(float64)
+			lines[int(offset)] = hitMap["_source"]
+		}
+	}
+
+	offsets := make([]int, 0, len(lines))
+	for offset := range lines {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	matching := make([]interface{}, 0, len(offsets))
+	for _, offset := range offsets {
+		matching = append(matching, lines[offset])
+	}
+
+	return matching
+}
+
+func (m lineMatcher) CompileAnd(expr *AndExpr) (interface{}, error) {
+	predicates, err := m.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for _, predicate := range predicates {
+			if !predicate(line) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+func (m lineMatcher) CompileOr(expr *OrExpr) (interface{}, error) {
+	predicates, err := m.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for _, predicate := range predicates {
+			if predicate(line) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (m lineMatcher) CompileNot(expr *NotExpr) (interface{}, error) {
+	predicates, err := m.compileChildren(expr.Children)
+	if err != nil {
+		return nil, err
+	}
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for _, predicate := range predicates {
+			if predicate(line) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+func (m lineMatcher) CompileTerms(expr *TermsExpr) (interface{}, error) {
+	field := m.field(expr.Field)
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for _, value := range expr.Values {
+			if compareValues(line[field], value) == 0 {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (m lineMatcher) CompileRange(expr *RangeExpr) (interface{}, error) {
+	field := m.field(expr.Field)
+	bounds := expr.Bounds()
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for operator, value := range bounds {
+			comparison := compareValues(line[field], value)
+			if comparison == notComparable {
+				return false
+			}
+
+			var ok bool
+			switch operator {
+			case "gt":
+				ok = comparison > 0
+			case "gte":
+				ok = comparison >= 0
+			case "lt":
+				ok = comparison < 0
+			case "lte":
+				ok = comparison <= 0
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// CompileMatch uses the regular expression of the MongoDB filter, so the same lines match
+func (m lineMatcher) CompileMatch(expr *MatchExpr) (interface{}, error) {
+	text, isText := expr.Query.(string)
+
+	var pattern *regexp.Regexp
+	if isText {
+		var err error
+		if pattern, err = regexp.Compile("(?i)" + textPattern(expr, text)); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make([]string, 0, len(expr.Fields))
+	for _, field := range expr.Fields {
+		fields = append(fields, m.field(field))
+	}
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		for _, field := range fields {
+			if !isText {
+				if compareValues(line[field], expr.Query) == 0 {
+					return true
+				}
+				continue
+			}
+			if value, ok := line[field].(string); ok && pattern.MatchString(value) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (m lineMatcher) CompileElemMatch(expr *ElemMatchExpr) (interface{}, error) {
+	return nil, fmt.Errorf("product line filters cannot be nested")
+}
+
+func (m lineMatcher) CompileExists(expr *ExistsExpr) (interface{}, error) {
+	field := m.field(expr.Field)
+
+	return linePredicate(func(line map[string]interface{}) bool {
+		return line[field] != nil
+	}), nil
+}
+
+func (m lineMatcher) field(field string) string {
+	return strings.TrimPrefix(field, m.path+".")
+}
+
+func (m lineMatcher) compileChildren(children []Expr) ([]linePredicate, error) {
+	predicates := make([]linePredicate, 0, len(children))
+	for _, child := range children {
+		predicate, err := child.Accept(m)
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate.(linePredicate))
+	}
+
+	return predicates, nil
+}
+
+// notComparable is returned by compareValues for a number and a text, MongoDB does not match them either
+const notComparable = 2
+
+// compareValues compares numbers by value and texts in byte order, it returns -1, 0, 1 or notComparable
+func compareValues(a interface{}, b interface{}) int {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return notComparable
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	x, ok := a.(string)
+	if !ok {
+		return notComparable
+	}
+	y, ok := b.(string)
+	if !ok {
+		return notComparable
+	}
+
+	return strings.Compare(x, y)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,89 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestMatchingLines(t *testing.T) {
+	lines := []models.ProductLine{
+		{Name: "Blue Pen", Quantity: 1, Price: 5},
+		{Name: "Red Pen", Quantity: 3, Price: 5},
+		{Name: "Notebook", Quantity: 2, Price: 20},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "every condition matches the same line",
+			body:     `{"matching_lines": true, "product_lines": [{"search": [{"fields": ["name"], "query": "pen"}], "range": {"quantity": {"gte": 2}}}]}`,
+			expected: []string{"Red Pen"},
+		},
+		{
+			name:     "lines of any filter in the order of the order",
+			body:     `{"matching_lines": true, "product_lines": [{"exact_filters": {"name": ["Notebook"]}}, {"range": {"price": {"lt": 10}}}]}`,
+			expected: []string{"Blue Pen", "Red Pen", "Notebook"},
+		},
+		{
+			name:     "number as text",
+			body:     `{"matching_lines": true, "product_lines": [{"exact_filters": {"price": ["20"]}}]}`,
+			expected: []string{"Notebook"},
+		},
+		{
+			name:     "fuzzy name",
+			body:     `{"matching_lines": true, "product_lines": [{"search": [{"fields": ["name"], "query": "notbook", "mode": "fuzzy"}]}]}`,
+			expected: []string{"Notebook"},
+		},
+		{
+			name:     "no matching line",
+			body:     `{"matching_lines": true, "product_lines": [{"range": {"price": {"gt": 100}}}]}`,
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parseRequest(t, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := &models.Order{Product: append([]models.ProductLine(nil), lines...)}
+			if err := MatchingLines(order, query.Lines); err != nil {
+				t.Fatal(err)
+			}
+
+			names := make([]string, 0, len(order.Product))
+			for _, line := range order.Product {
+				names = append(names, line.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		a, b     interface{}
+		expected int
+	}{
+		{3, 2.5, 1},
+		{int64(2), float64(2), 0},
+		{"a", "b", -1},
+		{5.0, "5", notComparable},
+		{"5", 5.0, notComparable},
+		{nil, "a", notComparable},
+	}
+
+	for _, test := range tests {
+		if comparison := compareValues(test.a, test.b); comparison != test.expected {
+			t.Errorf("%#v and %#v: expected %d, got %d", test.a, test.b, test.expected, comparison)
+		}
+	}
+}
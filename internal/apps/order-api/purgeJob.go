@@ -0,0 +1,78 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/internal/repository"
+	"context"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// PurgeJob deletes soft deleted orders from the database when they are older than the retention,
+// they are deleted from Elasticsearch with the outbox events of the deletion
+type PurgeJob struct {
+	Repository *repository.Repository
+	Retention  time.Duration
+	Interval   time.Duration
+	BatchSize  int64
+}
+
+func NewPurgeJob(repository *repository.Repository, retention time.Duration, interval time.Duration) *PurgeJob {
+	job := &PurgeJob{
+		Repository: repository,
+		Retention:  retention,
+		Interval:   interval,
+		BatchSize:  1000,
+	}
+	return job
+}
+
+// Start purges deleted orders every interval until the context is cancelled
+func (j *PurgeJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Purge job is stopped")
+			return
+		case <-ticker.C:
+			purged, err := j.Purge()
+			if err != nil {
+				log.Errorf("Error purging deleted orders: %v", err)
+			}
+			if purged > 0 {
+				log.Infof("%d deleted orders are purged", purged)
+			}
+		}
+	}
+}
+
+// Purge deletes every order which is soft deleted before the retention and returns the number of purged orders
+func (j *PurgeJob) Purge() (int64, error) {
+	before := time.Now().Add(-j.Retention)
+
+	var purged int64
+	for {
+		ids, err := j.Repository.GetDeletedBefore(before, j.BatchSize)
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		// An order restored after it is listed is not deleted
+		deleted, err := j.Repository.DeleteMany(ids, bson.M{"deletedAt": bson.M{"$lt": before}}, models.AuditActionPurge, models.AuditActorSystem)
+		if err != nil {
+			return purged, err
+		}
+		purged += deleted
+
+		if int64(len(ids)) < j.BatchSize {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxQueryDepth limits how deep query groups can be nested
+const MaxQueryDepth = 8
+
+// Modes of full-text match filters
+const (
+	MatchModeMatch  = "match"
+	MatchModePhrase = "match_phrase"
+	MatchModeMulti  = "multi_match"
+	MatchModeFuzzy  = "fuzzy"
+)
+
+var fuzzinessValues = map[string]bool{"": true, "AUTO": true, "0": true, "1": true, "2": true}
+
+// Fields of a product line filter, they are relative to the line
+var productLineFields = map[string]bool{"name": true, "quantity": true, "price": true}
+
+// Query is the parsed and validated form of OrderGetRequest. MongoDB and Elasticsearch compile the same Query,
+// so an operator is parsed once and behaves the same way on both stores.
+type Query struct {
+	Filter Expr
+	Fields []string
+	Sort   []SortClause
+	Limit  int
+	Offset int
+	// Sort values of the last item of the previous page (decoded cursor)
+	After []interface{}
+	// Product line filters whose matching lines are returned instead of every line
+	Lines []*ElemMatchExpr
+}
+
+// SortClause is one key of the sort order, MissingFirst places documents without the field before the others
+type SortClause struct {
+	Field        string
+	Descending   bool
+	MissingFirst bool
+}
+
+// Expr is a node of the filter tree
+type Expr interface {
+	Accept(compiler ExprCompiler) (interface{}, error)
+}
+
+// ExprCompiler translates filter nodes to the query language of a backend
+type ExprCompiler interface {
+	CompileAnd(expr *AndExpr) (interface{}, error)
+	CompileOr(expr *OrExpr) (interface{}, error)
+	CompileNot(expr *NotExpr) (interface{}, error)
+	CompileTerms(expr *TermsExpr) (interface{}, error)
+	CompileRange(expr *RangeExpr) (interface{}, error)
+	CompileMatch(expr *MatchExpr) (interface{}, error)
+	CompileElemMatch(expr *ElemMatchExpr) (interface{}, error)
+	CompileExists(expr *ExistsExpr) (interface{}, error)
+}
+
+// AndExpr matches when every child matches, an empty AndExpr matches every document
+type AndExpr struct {
+	Children []Expr
+}
+
+// OrExpr matches when at least one child matches
+type OrExpr struct {
+	Children []Expr
+}
+
+// NotExpr matches when none of the children match
+type NotExpr struct {
+	Children []Expr
+}
+
+// TermsExpr matches when the field is equal to one of the values
+type TermsExpr struct {
+	Field  string
+	Values []interface{}
+}
+
+// RangeExpr matches when the field is within the bounds, nil bounds are ignored
+type RangeExpr struct {
+	Field string
+	Gt    interface{}
+	Gte   interface{}
+	Lt    interface{}
+	Lte   interface{}
+}
+
+// MatchExpr is a full-text condition on one or more fields (multi_match)
+type MatchExpr struct {
+	Fields    []string
+	Query     interface{}
+	Mode      string
+	Fuzziness string
+}
+
+// ElemMatchExpr matches when one element of the array at Path matches the filter, fields of the filter
+// are full paths like product.name. Name is set when the matching elements are returned.
+type ElemMatchExpr struct {
+	Path   string
+	Filter *AndExpr
+	Name   string
+}
+
+// ExistsExpr matches when the field has a value which is not null
+type ExistsExpr struct {
+	Field string
+}
+
+func (e *AndExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileAnd(e)
+}
+
+func (e *OrExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileOr(e)
+}
+
+func (e *NotExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileNot(e)
+}
+
+func (e *TermsExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileTerms(e)
+}
+
+func (e *RangeExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileRange(e)
+}
+
+func (e *MatchExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileMatch(e)
+}
+
+func (e *ElemMatchExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileElemMatch(e)
+}
+
+func (e *ExistsExpr) Accept(compiler ExprCompiler) (interface{}, error) {
+	return compiler.CompileExists(e)
+}
+
+// Bounds returns the range operators (gt, gte, lt, lte) which are set
+func (e *RangeExpr) Bounds() map[string]interface{} {
+	bounds := make(map[string]interface{})
+	for operator, value := range map[string]interface{}{"gt": e.Gt, "gte": e.Gte, "lt": e.Lt, "lte": e.Lte} {
+		if value != nil {
+			bounds[operator] = value
+		}
+	}
+
+	return bounds
+}
+
+// ParseOrderGetRequest validates the request and converts it to a Query
+func ParseOrderGetRequest(req OrderGetRequest) (*Query, error) {
+	pageSize, err := req.PageSize()
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := parseFilter(req.OrderFilter)
+	if err != nil {
+		return nil, err
+	}
+	excludeDeleted(filter, req.IncludeDeleted)
+
+	for _, field := range req.Fields {
+		if err := validateField(field); err != nil {
+			return nil, err
+		}
+	}
+
+	query := &Query{
+		Filter: filter,
+		Fields: req.Fields,
+		Limit:  pageSize,
+		Offset: req.Offset,
+	}
+
+	if query.Sort, err = parseSort(req.Sort); err != nil {
+		return nil, err
+	}
+
+	if req.Cursor != "" {
+		if query.After, err = decodeCursor(req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.MatchingLines {
+		// Product line filters of the request are the first ElemMatchExpr children of the root
+		for _, child := range filter.Children {
+			if elemMatch, ok := child.(*ElemMatchExpr); ok {
+				elemMatch.Name = fmt.Sprintf("product_lines_%d", len(query.Lines))
+				query.Lines = append(query.Lines, elemMatch)
+			}
+		}
+		if len(query.Lines) == 0 {
+			return nil, &pkg.BadRequestError{Message: "matching_lines needs at least one product_lines filter"}
+		}
+	}
+
+	return query, nil
+}
+
+// parseFilter converts the filter section of a request to the filter tree
+func parseFilter(orderFilter OrderFilter) (*AndExpr, error) {
+	root := QueryGroup{
+		ExactFilters: orderFilter.ExactFilters,
+		Range:        orderFilter.Range,
+		Search:       orderFilter.Search,
+		ProductLines: orderFilter.ProductLines,
+	}
+	if orderFilter.Query != nil {
+		root.And = []QueryGroup{*orderFilter.Query}
+	}
+
+	filter, err := parseGroup(root, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Match is a shorthand for search filters in match mode
+	for _, field := range sortedKeys(orderFilter.Match) {
+		matchExpr, err := parseMatch(MatchFilter{Fields: []string{field}, Query: orderFilter.Match[field]})
+		if err != nil {
+			return nil, err
+		}
+		filter.Children = append(filter.Children, matchExpr)
+	}
+
+	return filter, nil
+}
+
+// excludeDeleted adds the condition which leaves out soft deleted orders unless they are included
+func excludeDeleted(filter *AndExpr, includeDeleted bool) {
+	if includeDeleted {
+		return
+	}
+
+	filter.Children = append(filter.Children, &NotExpr{Children: []Expr{&ExistsExpr{Field: "deletedAt"}}})
+}
+
+// parseGroup converts a query group to an AndExpr, Or and Not groups become OrExpr and NotExpr children
+func parseGroup(group QueryGroup, depth int) (*AndExpr, error) {
+	if depth > MaxQueryDepth {
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("query groups cannot be nested deeper than %d", MaxQueryDepth)}
+	}
+
+	and := &AndExpr{}
+
+	for _, field := range sortedKeys(group.ExactFilters) {
+		fieldType, err := validateValueField(field)
+		if err != nil {
+			return nil, err
+		}
+		// An exact filter without values does not restrict the result
+		if len(group.ExactFilters[field]) == 0 {
+			continue
+		}
+
+		values := make([]interface{}, 0, len(group.ExactFilters[field]))
+		for _, value := range group.ExactFilters[field] {
+			coerced, err := coerceValue(field, fieldType, value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, coerced)
+		}
+		and.Children = append(and.Children, &TermsExpr{Field: field, Values: values})
+	}
+
+	for _, field := range sortedKeys(group.Range) {
+		fieldType, err := validateValueField(field)
+		if err != nil {
+			return nil, err
+		}
+
+		rangeExpr, err := parseRange(field, fieldType, group.Range[field])
+		if err != nil {
+			return nil, err
+		}
+		if len(rangeExpr.Bounds()) == 0 {
+			continue
+		}
+		and.Children = append(and.Children, rangeExpr)
+	}
+
+	for _, matchFilter := range group.Search {
+		matchExpr, err := parseMatch(matchFilter)
+		if err != nil {
+			return nil, err
+		}
+		and.Children = append(and.Children, matchExpr)
+	}
+
+	for _, lineFilter := range group.ProductLines {
+		elemMatch, err := parseProductLine(lineFilter, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		and.Children = append(and.Children, elemMatch)
+	}
+
+	// Empty groups match every document, they are left out so an empty request has an empty filter
+	for _, child := range group.And {
+		childExpr, err := parseGroup(child, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(childExpr.Children) == 0 {
+			continue
+		}
+		and.Children = append(and.Children, childExpr)
+	}
+
+	if len(group.Or) > 0 {
+		or := &OrExpr{}
+		matchesAll := false
+		for _, child := range group.Or {
+			childExpr, err := parseGroup(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			matchesAll = matchesAll || len(childExpr.Children) == 0
+			or.Children = append(or.Children, childExpr)
+		}
+		if !matchesAll {
+			and.Children = append(and.Children, or)
+		}
+	}
+
+	if len(group.Not) > 0 {
+		not := &NotExpr{}
+		for _, child := range group.Not {
+			childExpr, err := parseGroup(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			not.Children = append(not.Children, childExpr)
+		}
+		and.Children = append(and.Children, not)
+	}
+
+	return and, nil
+}
+
+// parseProductLine converts the fields of the line filter to product fields and parses it like a query group
+func parseProductLine(lineFilter ProductLineFilter, depth int) (*ElemMatchExpr, error) {
+	const path = "product"
+
+	lineField := func(field string) (string, error) {
+		if !productLineFields[field] {
+			return "", &pkg.BadRequestError{Message: fmt.Sprintf("product line field %q must be name, quantity or price", field)}
+		}
+		return path + "." + field, nil
+	}
+
+	group := QueryGroup{
+		ExactFilters: make(map[string][]interface{}),
+		Range:        make(map[string]RangeFilter),
+	}
+
+	for field, values := range lineFilter.ExactFilters {
+		productField, err := lineField(field)
+		if err != nil {
+			return nil, err
+		}
+		group.ExactFilters[productField] = values
+	}
+
+	for field, rangeFilter := range lineFilter.Range {
+		productField, err := lineField(field)
+		if err != nil {
+			return nil, err
+		}
+		group.Range[productField] = rangeFilter
+	}
+
+	for _, matchFilter := range lineFilter.Search {
+		fields := make([]string, 0, len(matchFilter.Fields))
+		for _, field := range matchFilter.Fields {
+			productField, err := lineField(field)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, productField)
+		}
+		matchFilter.Fields = fields
+		group.Search = append(group.Search, matchFilter)
+	}
+
+	filter, err := parseGroup(group, depth)
+	if err != nil {
+		return nil, err
+	}
+	if len(filter.Children) == 0 {
+		return nil, &pkg.BadRequestError{Message: "product line filter needs at least one condition"}
+	}
+
+	return &ElemMatchExpr{Path: path, Filter: filter}, nil
+}
+
+// parseRange converts the bounds to the type of the field like exact filters, numbers can be sent as texts
+func parseRange(field string, fieldType fieldType, rangeFilter RangeFilter) (*RangeExpr, error) {
+	if fieldType == fieldKeyword {
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("range filter of %q needs a numeric or date field", field)}
+	}
+
+	rangeExpr := &RangeExpr{Field: field}
+
+	for _, bound := range []struct {
+		value  interface{}
+		target *interface{}
+	}{
+		{rangeFilter.Gt, &rangeExpr.Gt},
+		{rangeFilter.Gte, &rangeExpr.Gte},
+		{rangeFilter.Lt, &rangeExpr.Lt},
+		{rangeFilter.Lte, &rangeExpr.Lte},
+	} {
+		if bound.value == nil {
+			continue
+		}
+
+		// Bounds of integer fields can be fractions
+		boundType := fieldType
+		if boundType == fieldInteger {
+			boundType = fieldNumber
+		}
+
+		coerced, err := coerceValue(field, boundType, bound.value)
+		if err != nil {
+			return nil, err
+		}
+		*bound.target = coerced
+	}
+
+	return rangeExpr, nil
+}
+
+func parseMatch(matchFilter MatchFilter) (*MatchExpr, error) {
+	matchExpr := &MatchExpr{
+		Fields:    matchFilter.Fields,
+		Query:     matchFilter.Query,
+		Mode:      matchFilter.Mode,
+		Fuzziness: strings.ToUpper(matchFilter.Fuzziness),
+	}
+
+	if matchExpr.Mode == "" {
+		matchExpr.Mode = MatchModeMatch
+	}
+
+	switch matchExpr.Mode {
+	case MatchModeMatch, MatchModePhrase, MatchModeFuzzy:
+		if len(matchExpr.Fields) != 1 {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("%s search needs exactly one field", matchExpr.Mode)}
+		}
+	case MatchModeMulti:
+		if len(matchExpr.Fields) == 0 {
+			return nil, &pkg.BadRequestError{Message: "multi_match search needs at least one field"}
+		}
+	default:
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("search mode %q is not supported", matchExpr.Mode)}
+	}
+
+	if matchExpr.Query == nil || matchExpr.Query == "" {
+		return nil, &pkg.BadRequestError{Message: fmt.Sprintf("search on %v needs a query", matchExpr.Fields)}
+	}
+
+	// Text search needs text fields, other fields are matched by value in match mode
+	for _, field := range matchExpr.Fields {
+		fieldType, err := validateValueField(field)
+		if err != nil {
+			return nil, err
+		}
+		if fieldType == fieldKeyword {
+			if _, ok := matchExpr.Query.(string); !ok {
+				if matchExpr.Query, err = coerceValue(field, fieldType, matchExpr.Query); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if matchExpr.Mode != MatchModeMatch {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("%s search needs a text field, %q is not", matchExpr.Mode, field)}
+		}
+		if matchExpr.Query, err = coerceValue(field, fieldType, matchExpr.Query); err != nil {
+			return nil, err
+		}
+	}
+
+	if !fuzzinessValues[matchExpr.Fuzziness] {
+		return nil, &pkg.BadRequestError{Message: "fuzziness must be AUTO, 0, 1 or 2"}
+	}
+
+	if matchExpr.Mode == MatchModePhrase && matchExpr.Fuzziness != "" {
+		return nil, &pkg.BadRequestError{Message: "match_phrase search does not support fuzziness"}
+	}
+
+	if matchExpr.Mode == MatchModeFuzzy && matchExpr.Fuzziness == "" {
+		matchExpr.Fuzziness = "AUTO"
+	}
+
+	return matchExpr, nil
+}
+
+func parseSort(sortRequests []SortRequest) ([]SortClause, error) {
+	clauses := make([]SortClause, 0, len(sortRequests))
+	seen := make(map[string]bool)
+
+	for _, sortRequest := range sortRequests {
+		if _, err := validateValueField(sortRequest.Field); err != nil {
+			return nil, err
+		}
+		if seen[sortRequest.Field] {
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("field %q is sorted more than once", sortRequest.Field)}
+		}
+		seen[sortRequest.Field] = true
+
+		clause := SortClause{Field: sortRequest.Field}
+
+		switch strings.ToLower(sortRequest.Direction) {
+		case "", "asc":
+		case "desc":
+			clause.Descending = true
+		default:
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("sort direction of %q must be asc or desc", sortRequest.Field)}
+		}
+
+		switch strings.ToLower(sortRequest.Missing) {
+		case "", "last":
+		case "first":
+			clause.MissingFirst = true
+		default:
+			return nil, &pkg.BadRequestError{Message: fmt.Sprintf("missing values of %q must be placed first or last", sortRequest.Field)}
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	return clauses, nil
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
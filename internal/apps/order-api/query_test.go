@@ -0,0 +1,219 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseRequest parses the json body of a generic query like the handler
+func parseRequest(t *testing.T, body string) (*Query, error) {
+	t.Helper()
+
+	var req OrderGetRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return ParseOrderGetRequest(req)
+}
+
+func TestParseOrderGetRequestBuildsTheFilterTree(t *testing.T) {
+	notDeleted := &NotExpr{Children: []Expr{&ExistsExpr{Field: "deletedAt"}}}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected Expr
+	}{
+		{
+			name:     "empty request leaves out deleted orders",
+			body:     `{}`,
+			expected: &AndExpr{Children: []Expr{notDeleted}},
+		},
+		{
+			name:     "deleted orders included",
+			body:     `{"include_deleted": true}`,
+			expected: &AndExpr{},
+		},
+		{
+			name: "filters are sorted by field",
+			body: `{"include_deleted": true, "exact_filters": {"status": ["created"], "city": ["Ankara", "Izmir"]}}`,
+			expected: &AndExpr{Children: []Expr{
+				&TermsExpr{Field: "city", Values: []interface{}{"Ankara", "Izmir"}},
+				&TermsExpr{Field: "status", Values: []interface{}{"created"}},
+			}},
+		},
+		{
+			name: "match is a search in match mode",
+			body: `{"include_deleted": true, "match": {"city": "ankara"}}`,
+			expected: &AndExpr{Children: []Expr{
+				&MatchExpr{Fields: []string{"city"}, Query: "ankara", Mode: MatchModeMatch},
+			}},
+		},
+		{
+			name: "fuzzy search has AUTO fuzziness by default",
+			body: `{"include_deleted": true, "search": [{"fields": ["city"], "query": "ankra", "mode": "fuzzy"}]}`,
+			expected: &AndExpr{Children: []Expr{
+				&MatchExpr{Fields: []string{"city"}, Query: "ankra", Mode: MatchModeFuzzy, Fuzziness: "AUTO"},
+			}},
+		},
+		{
+			name: "query groups",
+			body: `{"include_deleted": true, "query": {
+				"or": [{"exact_filters": {"city": ["Ankara"]}}, {"range": {"total": {"gte": 100}}}],
+				"not": [{"exact_filters": {"status": ["cancelled"]}}]
+			}}`,
+			expected: &AndExpr{Children: []Expr{
+				&AndExpr{Children: []Expr{
+					&OrExpr{Children: []Expr{
+						&AndExpr{Children: []Expr{&TermsExpr{Field: "city", Values: []interface{}{"Ankara"}}}},
+						&AndExpr{Children: []Expr{&RangeExpr{Field: "total", Gte: float64(100)}}},
+					}},
+					&NotExpr{Children: []Expr{
+						&AndExpr{Children: []Expr{&TermsExpr{Field: "status", Values: []interface{}{"cancelled"}}}},
+					}},
+				}},
+			}},
+		},
+		{
+			name: "product line fields are relative to the line",
+			body: `{"include_deleted": true, "product_lines": [{"exact_filters": {"name": ["Pen"]}, "range": {"quantity": {"gte": 2}}}]}`,
+			expected: &AndExpr{Children: []Expr{
+				&ElemMatchExpr{Path: "product", Filter: &AndExpr{Children: []Expr{
+					&TermsExpr{Field: "product.name", Values: []interface{}{"Pen"}},
+					&RangeExpr{Field: "product.quantity", Gte: float64(2)},
+				}}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parseRequest(t, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(query.Filter, test.expected) {
+				t.Errorf("expected %s, got %s", dump(test.expected), dump(query.Filter))
+			}
+		})
+	}
+}
+
+func TestParseOrderGetRequestRejectsInvalidRequests(t *testing.T) {
+	tooDeep := `{"exact_filters": {"city": ["Ankara"]}}`
+	for i := 0; i <= MaxQueryDepth; i++ {
+		tooDeep = `{"and": [` + tooDeep + `]}`
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"unknown field", `{"exact_filters": {"unknown": ["x"]}}`, `"unknown"`},
+		{"mongodb operator as field", `{"exact_filters": {"$where": ["1"]}}`, `"$where"`},
+		{"text as number", `{"exact_filters": {"total": ["abc"]}}`, "total"},
+		{"range of a keyword field", `{"range": {"city": {"gte": "a"}}}`, "numeric or date field"},
+		{"groups nested too deep", `{"query": ` + tooDeep + `}`, "nested deeper"},
+		{"match without a field", `{"search": [{"query": "x"}]}`, "exactly one field"},
+		{"multi_match without fields", `{"search": [{"query": "x", "mode": "multi_match"}]}`, "at least one field"},
+		{"unknown search mode", `{"search": [{"fields": ["city"], "query": "x", "mode": "regex"}]}`, `"regex"`},
+		{"search without query", `{"search": [{"fields": ["city"]}]}`, "needs a query"},
+		{"phrase on a number", `{"search": [{"fields": ["total"], "query": "1", "mode": "match_phrase"}]}`, "text field"},
+		{"phrase with fuzziness", `{"search": [{"fields": ["city"], "query": "x", "mode": "match_phrase", "fuzziness": "1"}]}`, "fuzziness"},
+		{"invalid fuzziness", `{"search": [{"fields": ["city"], "query": "x", "fuzziness": "3"}]}`, "fuzziness"},
+		{"unknown product line field", `{"product_lines": [{"exact_filters": {"city": ["x"]}}]}`, "product line field"},
+		{"empty product line filter", `{"product_lines": [{}]}`, "at least one condition"},
+		{"field sorted twice", `{"sort": [{"field": "city"}, {"field": "city", "direction": "desc"}]}`, "more than once"},
+		{"invalid sort direction", `{"sort": [{"field": "city", "direction": "up"}]}`, "asc or desc"},
+		{"invalid missing", `{"sort": [{"field": "city", "missing": "middle"}]}`, "first or last"},
+		{"unknown projected field", `{"fields": ["password"]}`, `"password"`},
+		{"limit over the maximum", `{"limit": 1001}`, "limit"},
+		{"negative offset", `{"offset": -1}`, "offset"},
+		{"offset with cursor", `{"offset": 10, "cursor": "abc"}`, "together"},
+		{"invalid cursor", `{"cursor": "not a cursor"}`, "cursor"},
+		{"matching lines without line filters", `{"matching_lines": true}`, "product_lines"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseRequest(t, test.body)
+
+			var badRequestError *pkg.BadRequestError
+			if !errors.As(err, &badRequestError) {
+				t.Fatalf("expected a bad request, got %v", err)
+			}
+			if !strings.Contains(badRequestError.Message, test.message) {
+				t.Errorf("expected %q in the message, got %q", test.message, badRequestError.Message)
+			}
+		})
+	}
+}
+
+func TestParseOrderGetRequestNamesMatchingLines(t *testing.T) {
+	query, err := parseRequest(t, `{
+		"matching_lines": true,
+		"product_lines": [{"exact_filters": {"name": ["Pen"]}}, {"range": {"price": {"gt": 10}}}],
+		"query": {"product_lines": [{"exact_filters": {"name": ["Book"]}}]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Line filters in query groups are not used for the matching lines
+	if len(query.Lines) != 2 {
+		t.Fatalf("expected 2 line filters, got %d", len(query.Lines))
+	}
+	for i, name := range []string{"product_lines_0", "product_lines_1"} {
+		if query.Lines[i].Name != name {
+			t.Errorf("expected line filter %d to be named %s, got %q", i, name, query.Lines[i].Name)
+		}
+	}
+}
+
+func TestParseOrderGetRequestPage(t *testing.T) {
+	cursor, err := encodeCursor([]interface{}{"Ankara", "order-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		req    OrderGetRequest
+		limit  int
+		offset int
+		after  []interface{}
+	}{
+		{"default page", OrderGetRequest{}, DefaultPageSize, 0, nil},
+		{"limit and offset", OrderGetRequest{Limit: 10, Offset: 20}, 10, 20, nil},
+		{"cursor", OrderGetRequest{Limit: 10, Cursor: cursor}, 10, 0, []interface{}{"Ankara", "order-1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := ParseOrderGetRequest(test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query.Limit != test.limit || query.Offset != test.offset || !reflect.DeepEqual(query.After, test.after) {
+				t.Errorf("expected limit %d, offset %d and after %v, got %d, %d and %v",
+					test.limit, test.offset, test.after, query.Limit, query.Offset, query.After)
+			}
+		})
+	}
+}
+
+// dump prints a filter tree with the contents of the pointers
+func dump(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(data)
+}
@@ -0,0 +1,55 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Layouts accepted for absolute dates in range filters
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// Relative dates like "now", "now-7d" or "now+12h"
+var relativeDatePattern = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?$`)
+
+var relativeDateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseDate resolves dates to time.Time, so MongoDB and Elasticsearch get the same instant instead of
+// interpreting "now-7d" on their own
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	matches := relativeDatePattern.FindStringSubmatch(value)
+	if matches == nil {
+		return time.Time{}, &pkg.BadRequestError{Message: fmt.Sprintf("range value %q is neither a number nor a date", value)}
+	}
+
+	now := time.Now().UTC()
+	if matches[1] == "" {
+		return now, nil
+	}
+
+	amount, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return time.Time{}, &pkg.BadRequestError{Message: fmt.Sprintf("range value %q is not a valid relative date", value)}
+	}
+
+	offset := time.Duration(amount) * relativeDateUnits[matches[3]]
+	if matches[1] == "-" {
+		offset = -offset
+	}
+
+	return now.Add(offset), nil
+}
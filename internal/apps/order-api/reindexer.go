@@ -0,0 +1,144 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/repository"
+	"fmt"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// Reindexer copies every order from MongoDB to the next version of the order index and moves the alias to it.
+// Services keep using the old index through the alias until the copy is verified.
+type Reindexer struct {
+	Repository     *repository.Repository
+	ElasticService *ElasticService
+	BatchSize      int64
+}
+
+func NewReindexer(repository *repository.Repository, elasticService *ElasticService) *Reindexer {
+	reindexer := &Reindexer{
+		Repository:     repository,
+		ElasticService: elasticService,
+		BatchSize:      1000,
+	}
+	return reindexer
+}
+
+// Reindex creates the new index, copies the orders, verifies the count and swaps the alias. It returns the new index.
+func (r *Reindexer) Reindex() (string, error) {
+	alias := r.ElasticService.Config.Elasticsearch.AliasName["Order"]
+
+	if err := r.ElasticService.EnsureOrderIndex(); err != nil {
+		return "", err
+	}
+
+	oldIndices, err := r.ElasticService.GetAliasIndices(alias)
+	if err != nil {
+		return "", err
+	}
+
+	// Indices are sorted, the last one has the highest version
+	index, err := NextIndexName(oldIndices[len(oldIndices)-1])
+	if err != nil {
+		return "", err
+	}
+
+	exists, err := r.ElasticService.IndexExists(index)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("index %s already exists, it can be left from a failed reindex and must be deleted first", index)
+	}
+
+	if err := r.ElasticService.CreateOrderIndex(index); err != nil {
+		return "", err
+	}
+	log.Infof("Index {%s} is created", index)
+
+	startedAt := time.Now()
+
+	// Orders in MongoDB are counted before and after the copy, orders created or purged during the copy can be
+	// in the new index or not
+	countBefore, err := r.Repository.CountOrdersWithFilter(bson.M{})
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := r.copyOrders(index, bson.M{}); err != nil {
+		return "", err
+	}
+
+	countAfter, err := r.Repository.CountOrdersWithFilter(bson.M{})
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.ElasticService.RefreshIndex(index); err != nil {
+		return "", err
+	}
+
+	count, err := r.ElasticService.CountDocuments(index)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyCopiedCount(count, countBefore, countAfter); err != nil {
+		return "", fmt.Errorf("index %s is not complete, alias is not changed: %w", index, err)
+	}
+
+	if err := r.ElasticService.SwapAlias(alias, index, oldIndices); err != nil {
+		return "", err
+	}
+	log.Infof("Alias {%s} is moved from %v to {%s} with %d orders", alias, oldIndices, index, count)
+
+	// Orders changed during the copy are written to the old index by the API, so they are copied again.
+	// Orders deleted during the copy are not known here and stay in the new index.
+	if _, err := r.copyOrders(index, bson.M{"updatedAt": bson.M{"$gte": startedAt}}); err != nil {
+		return index, err
+	}
+
+	return index, nil
+}
+
+// verifyCopiedCount checks the number of orders in the new index against the number of orders in MongoDB when
+// the copy started and ended, the count can be anywhere between them because of concurrent writes
+func verifyCopiedCount(count int64, countBefore int64, countAfter int64) error {
+	low, high := countBefore, countAfter
+	if low > high {
+		low, high = high, low
+	}
+
+	if count < low || count > high {
+		if low == high {
+			return fmt.Errorf("index has %d orders but mongodb has %d orders", count, low)
+		}
+		return fmt.Errorf("index has %d orders but mongodb has between %d and %d orders", count, low, high)
+	}
+
+	return nil
+}
+
+// copyOrders copies the orders matching the filter to the index in batches and returns the number of copied orders
+func (r *Reindexer) copyOrders(index string, filter bson.M) (int64, error) {
+	var copied int64
+	afterID := ""
+
+	for {
+		orders, err := r.Repository.GetOrdersAfterID(filter, afterID, r.BatchSize)
+		if err != nil {
+			return copied, err
+		}
+		if len(orders) == 0 {
+			return copied, nil
+		}
+
+		if err := r.ElasticService.BulkIndexOrders(index, orders); err != nil {
+			return copied, err
+		}
+
+		copied += int64(len(orders))
+		afterID = orders[len(orders)-1].ID
+		log.Infof("%d orders are copied to {%s}", copied, index)
+	}
+}
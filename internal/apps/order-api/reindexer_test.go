@@ -0,0 +1,30 @@
+package order_api
+
+import "testing"
+
+func TestVerifyCopiedCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int64
+		countBefore int64
+		countAfter  int64
+		valid       bool
+	}{
+		{"no writes during the copy", 100, 100, 100, true},
+		{"orders missing from the index", 90, 100, 100, false},
+		{"orders created during the copy are copied", 102, 100, 102, true},
+		{"orders created during the copy are not copied yet", 100, 100, 102, true},
+		{"orders purged during the copy", 99, 100, 98, true},
+		{"more orders than mongodb ever had", 103, 100, 102, false},
+		{"fewer orders than mongodb ever had", 97, 100, 98, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyCopiedCount(test.count, test.countBefore, test.countAfter)
+			if test.valid != (err == nil) {
+				t.Errorf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
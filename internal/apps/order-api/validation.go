@@ -0,0 +1,147 @@
+package order_api
+
+import (
+	"GenericEndpoint/internal/models"
+	"GenericEndpoint/pkg"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the validate tags of the request and returns every broken rule as a field error of a
+// BadRequestError. Rules are separated by commas:
+//
+//	required   the field is not empty, a slice has at least one element
+//	min=n      numbers are at least n, texts and slices have at least n characters or elements
+//	max=n      numbers are at most n, texts and slices have at most n characters or elements
+//	status     the text is empty or a valid order status
+//
+// Structs in slices and pointers are validated too, so the rules of product lines apply to every line.
+// A nil pointer is a field which is not sent, it passes every rule.
+func Validate(req interface{}) error {
+	var fieldErrors []pkg.FieldError
+	validateValue(reflect.ValueOf(req), "", &fieldErrors)
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		messages = append(messages, fieldError.Message)
+	}
+
+	return &pkg.BadRequestError{
+		Message: fmt.Sprintf("Request is not valid: %v", strings.Join(messages, "; ")),
+		Errors:  fieldErrors,
+	}
+}
+
+func validateValue(value reflect.Value, path string, fieldErrors *[]pkg.FieldError) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			validateValue(value.Elem(), path, fieldErrors)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			validateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), fieldErrors)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			fieldPath := jsonName(field)
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+
+			if tag := field.Tag.Get("validate"); tag != "" {
+				for _, rule := range strings.Split(tag, ",") {
+					if message := checkRule(value.Field(i), rule); message != "" {
+						*fieldErrors = append(*fieldErrors, pkg.FieldError{
+							Field:   fieldPath,
+							Rule:    rule,
+							Message: fmt.Sprintf("%s %s", fieldPath, message),
+						})
+					}
+				}
+			}
+
+			validateValue(value.Field(i), fieldPath, fieldErrors)
+		}
+	}
+}
+
+// checkRule returns why the value breaks the rule, an empty text is returned if it does not
+func checkRule(value reflect.Value, rule string) string {
+	name, param, _ := strings.Cut(rule, "=")
+
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	switch name {
+	case "required":
+		if value.IsZero() || (value.Kind() == reflect.Slice && value.Len() == 0) {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate rule %q has no number", rule))
+		}
+
+		size, unit := measure(value)
+		if name == "min" && size < limit {
+			return fmt.Sprintf("must be at least %v%s", param, unit)
+		}
+		if name == "max" && size > limit {
+			return fmt.Sprintf("must be at most %v%s", param, unit)
+		}
+	case "status":
+		if status := value.String(); status != "" && !models.IsValidStatus(status) {
+			return fmt.Sprintf("{%v} is not a valid status", status)
+		}
+	case "initial_status":
+		// Orders move to other statuses only with allowed transitions
+		if status := value.String(); status != "" && status != models.StatusCreated {
+			return fmt.Sprintf("must be %s or empty for a new order, not {%v}", models.StatusCreated, status)
+		}
+	default:
+		panic(fmt.Sprintf("validate rule %q is not known", rule))
+	}
+
+	return ""
+}
+
+// measure returns the number which is compared by min and max, with the unit of texts and slices
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(len([]rune(value.String()))), " characters"
+	case reflect.Slice:
+		return float64(value.Len()), " elements"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	default:
+		panic(fmt.Sprintf("validate rule cannot measure %v", value.Kind()))
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+
+	return field.Name
+}
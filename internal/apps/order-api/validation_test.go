@@ -0,0 +1,131 @@
+package order_api
+
+import (
+	"GenericEndpoint/pkg"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	validLine := ProductLineRequest{Name: "Pen", Quantity: 1, Price: 5}
+	validOrder := func() OrderCreateRequest {
+		return OrderCreateRequest{
+			UserID:        "user-1",
+			City:          "Ankara",
+			AddressDetail: "Kizilay",
+			Product:       []ProductLineRequest{validLine},
+		}
+	}
+	text := func(length int) *string {
+		value := strings.Repeat("a", length)
+		return &value
+	}
+	status := func(value string) *string {
+		return &value
+	}
+
+	tests := []struct {
+		name   string
+		req    interface{}
+		fields []string
+	}{
+		{
+			name: "valid order",
+			req:  validOrder(),
+		},
+		{
+			name:   "missing fields",
+			req:    OrderCreateRequest{},
+			fields: []string{"userId", "city", "addressDetail", "product"},
+		},
+		{
+			name: "rules of every product line",
+			req: func() OrderCreateRequest {
+				req := validOrder()
+				req.Product = []ProductLineRequest{validLine, {Name: "", Quantity: 0, Price: -1}}
+				return req
+			}(),
+			fields: []string{"product[1].name", "product[1].quantity", "product[1].price"},
+		},
+		{
+			name: "characters are counted, not bytes",
+			req: func() OrderCreateRequest {
+				req := validOrder()
+				req.City = strings.Repeat("ş", 100)
+				return req
+			}(),
+		},
+		{
+			name: "text too long",
+			req: func() OrderCreateRequest {
+				req := validOrder()
+				req.City = strings.Repeat("a", 101)
+				return req
+			}(),
+			fields: []string{"city"},
+		},
+		{
+			name: "new order with another status",
+			req: func() OrderCreateRequest {
+				req := validOrder()
+				req.Status = "delivered"
+				return req
+			}(),
+			fields: []string{"status"},
+		},
+		{
+			name:   "unknown status of an update",
+			req:    OrderUpdateRequest{UserID: "user-1", Status: "lost", City: "Ankara", AddressDetail: "Kizilay", Product: []ProductLineRequest{validLine}},
+			fields: []string{"status"},
+		},
+		{
+			name: "fields of a patch which are not sent",
+			req:  OrderPatchRequest{Status: status("delivered")},
+		},
+		{
+			name:   "fields of a patch which are sent",
+			req:    OrderPatchRequest{UserID: text(0), City: text(101), Product: &[]ProductLineRequest{}},
+			fields: []string{"userId", "city", "product"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.req)
+
+			if len(test.fields) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var badRequestError *pkg.BadRequestError
+			if !errors.As(err, &badRequestError) {
+				t.Fatalf("expected a bad request, got %v", err)
+			}
+
+			fields := make([]string, 0, len(badRequestError.Errors))
+			for _, fieldError := range badRequestError.Errors {
+				fields = append(fields, fieldError.Field)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("expected errors of %v, got %v", test.fields, badRequestError.Errors)
+			}
+		})
+	}
+}
+
+func TestValidatePanicsOnUnknownRules(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an unknown rule")
+		}
+	}()
+
+	_ = Validate(struct {
+		Name string `validate:"unknown"`
+	}{})
+}
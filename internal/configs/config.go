@@ -1,64 +1,88 @@
 package configs
 
+import "time"
+
+// Config is loaded by LoadConfig, the yaml names are used in config files and in environment variables
 type Config struct {
-	Server struct {
-		Port map[string]string
-		Host string
-	}
-	Database struct {
-		Connection          string
-		DatabaseName        string
-		UserCollectionName  string
-		OrderCollectionName string
-	}
-	Elasticsearch struct {
-		Addresses map[string]string
-		IndexName map[string]string
-	}
+	Server        ServerConfig        `yaml:"server"`
+	Database      DatabaseConfig      `yaml:"database"`
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	// Soft deleted orders are purged when they are older than the retention
+	SoftDelete SoftDeleteConfig `yaml:"softDelete"`
+	// Idempotency keys of created orders are kept for the TTL, a retry after it creates a new order
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+}
+
+type ServerConfig struct {
+	Port map[string]string `yaml:"port"`
+	Host string            `yaml:"host"`
+}
+
+type DatabaseConfig struct {
+	Connection                string `yaml:"connection"`
+	DatabaseName              string `yaml:"databaseName"`
+	UserCollectionName        string `yaml:"userCollectionName"`
+	OrderCollectionName       string `yaml:"orderCollectionName"`
+	OutboxCollectionName      string `yaml:"outboxCollectionName"`
+	ResumeTokenCollectionName string `yaml:"resumeTokenCollectionName"`
+	AuditCollectionName       string `yaml:"auditCollectionName"`
+	IdempotencyCollectionName string `yaml:"idempotencyCollectionName"`
+}
+
+type ElasticsearchConfig struct {
+	Addresses map[string]string `yaml:"addresses"`
+	IndexName map[string]string `yaml:"indexName"`
+	AliasName map[string]string `yaml:"aliasName"`
 }
 
-var Configs = map[string]Config{
-	"test": {
-		Server: struct {
-			Port map[string]string
-			Host string
-		}{
+type SoftDeleteConfig struct {
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purgeInterval"`
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// DefaultConfig returns the settings which are the same in every environment. Connection and addresses
+// have no defaults, they come from the config file of the environment or from environment variables.
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
 			Port: map[string]string{
 				"orderAPI": ":8011",
 			},
 			Host: "localhost",
 		},
-		Database: struct {
-			Connection          string
-			DatabaseName        string
-			UserCollectionName  string
-			OrderCollectionName string
-		}{
-			Connection:          "mongodb://localhost:27017",
-			DatabaseName:        "ProjectDB",
-			UserCollectionName:  "Users",
-			OrderCollectionName: "Orders",
+		Database: DatabaseConfig{
+			DatabaseName:              "ProjectDB",
+			UserCollectionName:        "Users",
+			OrderCollectionName:       "Orders",
+			OutboxCollectionName:      "OrderOutbox",
+			ResumeTokenCollectionName: "ResumeTokens",
+			AuditCollectionName:       "OrderAudit",
+			IdempotencyCollectionName: "IdempotencyKeys",
 		},
-		Elasticsearch: struct {
-			Addresses map[string]string
-			IndexName map[string]string
-		}{
+		Elasticsearch: ElasticsearchConfig{
+			// Keys are listed, so they can be set with environment variables
 			Addresses: map[string]string{
-				"Address 1": "http://localhost:9200",
+				"Address 1": "",
 			},
+			// First version of the index, reindex creates the next versions
 			IndexName: map[string]string{
 				"Order": "generic_endpoint_v01",
 			},
+			// Services read and write through the alias, so the index can be changed without downtime
+			AliasName: map[string]string{
+				"Order": "generic_endpoint",
+			},
+		},
+		SoftDelete: SoftDeleteConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: 24 * time.Hour,
 		},
-	},
-	"qa":   {},
-	"prod": {},
-}
-
-func GetConfig(env string) Config {
-	if conf, ok := Configs[env]; ok {
-		return conf
 	}
-
-	return Configs["test"]
 }
@@ -0,0 +1,216 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"gopkg.in/yaml.v2"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEnvironment is used when neither APP_ENV nor the env flag is given
+const DefaultEnvironment = "test"
+
+// EnvPrefix is the prefix of environment variables which override the config, e.g. ORDERAPI_DATABASE_CONNECTION
+const EnvPrefix = "ORDERAPI"
+
+// ConfigDir is searched for <env>.yaml, <env>.yml or <env>.json when no config file is given
+const ConfigDir = "config"
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// LoadConfig returns the config of the environment. Defaults are overridden by the config file and the config
+// file by the environment variables. The config is validated, so services can fail fast before connecting.
+func LoadConfig(env string, path string) (Config, error) {
+	if env == "" {
+		env = DefaultEnvironment
+	}
+
+	config := DefaultConfig()
+
+	if path == "" {
+		path = findConfigFile(env)
+	}
+	if path != "" {
+		if err := readConfigFile(path, &config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&config).Elem(), EnvPrefix); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("config of environment %q is not valid: %w", env, err)
+	}
+
+	return config, nil
+}
+
+// findConfigFile returns the config file of the environment in ConfigDir, empty when there is none
+func findConfigFile(env string) string {
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		path := filepath.Join(ConfigDir, env+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// readConfigFile reads YAML and JSON files with the same decoder, JSON is valid YAML.
+// Only the settings in the file are changed, the others keep their defaults.
+func readConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file cannot be read: %w", err)
+	}
+
+	// Strict decoding finds misspelled settings, it is done into an empty config because it rejects map keys
+	// which are already set by the defaults
+	if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+		return fmt.Errorf("config file %s cannot be parsed: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("config file %s cannot be parsed: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides the fields with environment variables named after the yaml names, camel case is split
+// with underscores. Map entries are overridden one by one, e.g. ORDERAPI_SERVER_PORT_ORDER_API.
+func applyEnv(v reflect.Value, prefix string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if err := applyEnv(v.Field(i), prefix+"_"+envName(name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if value, ok := os.LookupEnv(prefix + "_" + envName(key.String())); ok {
+				v.SetMapIndex(key, reflect.ValueOf(value))
+			}
+		}
+	default:
+		value, ok := os.LookupEnv(prefix)
+		if !ok {
+			return nil
+		}
+		return setValue(v, prefix, value)
+	}
+
+	return nil
+}
+
+func setValue(v reflect.Value, name string, value string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s is not a duration: %w", name, err)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	default:
+		return fmt.Errorf("%s cannot be set from the environment", name)
+	}
+
+	return nil
+}
+
+// envName converts a yaml name or a map key to the part of an environment variable, e.g. orderAPI to ORDER_API
+func envName(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		upper := r >= 'A' && r <= 'Z'
+		if upper && i > 0 && (runes[i-1] < 'A' || runes[i-1] > 'Z') {
+			b.WriteRune('_')
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToUpper(b.String()), "_"), "_")
+}
+
+// Validate returns all missing or invalid settings in one error
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Database.Connection != "", "database.connection (mongo uri) is missing")
+	if c.Database.Connection != "" {
+		_, err := connstring.ParseAndValidate(c.Database.Connection)
+		check(err == nil, "database.connection is not a valid mongo uri: %v", err)
+	}
+	check(c.Database.DatabaseName != "", "database.databaseName is missing")
+	collections := []struct{ name, value string }{
+		{"orderCollectionName", c.Database.OrderCollectionName},
+		{"outboxCollectionName", c.Database.OutboxCollectionName},
+		{"resumeTokenCollectionName", c.Database.ResumeTokenCollectionName},
+		{"auditCollectionName", c.Database.AuditCollectionName},
+		{"idempotencyCollectionName", c.Database.IdempotencyCollectionName},
+	}
+	for _, collection := range collections {
+		check(collection.value != "", "database.%s is missing", collection.name)
+	}
+
+	address := c.Elasticsearch.Addresses["Address 1"]
+	check(address != "", "elasticsearch.addresses.Address 1 is missing")
+	if address != "" {
+		u, err := url.Parse(address)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"elasticsearch address %q is not a valid http url", address)
+	}
+	index, alias := c.Elasticsearch.IndexName["Order"], c.Elasticsearch.AliasName["Order"]
+	check(index != "", "elasticsearch.indexName.Order is missing")
+	check(alias != "", "elasticsearch.aliasName.Order is missing")
+	check(index == "" || index != alias, "elasticsearch index and alias of orders cannot be the same")
+
+	port := c.Server.Port["orderAPI"]
+	check(port != "", "server.port.orderAPI is missing")
+	if port != "" {
+		check(validPort(port), "server.port.orderAPI %q is not a valid address like :8011", port)
+	}
+
+	check(c.SoftDelete.Retention > 0, "softDelete.retention must be positive")
+	check(c.SoftDelete.PurgeInterval > 0, "softDelete.purgeInterval must be positive")
+	check(c.Idempotency.TTL > 0, "idempotency.ttl must be positive")
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validPort checks a listen address of echo, the host can be empty
+func validPort(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
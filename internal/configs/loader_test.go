@@ -0,0 +1,210 @@
+package configs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() Config {
+	config := DefaultConfig()
+	config.Database.Connection = "mongodb://localhost:27017"
+	config.Elasticsearch.Addresses["Address 1"] = "http://localhost:9200"
+
+	return config
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"connection":   "CONNECTION",
+		"databaseName": "DATABASE_NAME",
+		"orderAPI":     "ORDER_API",
+		"softDelete":   "SOFT_DELETE",
+		"ttl":          "TTL",
+		"Order":        "ORDER",
+		"Address 1":    "ADDRESS_1",
+		"index-name":   "INDEX_NAME",
+	}
+
+	for name, expected := range tests {
+		if envName(name) != expected {
+			t.Errorf("%q: expected %s, got %s", name, expected, envName(name))
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("ORDERAPI_DATABASE_CONNECTION", "mongodb://db:27017")
+	t.Setenv("ORDERAPI_SERVER_PORT_ORDER_API", ":9000")
+	t.Setenv("ORDERAPI_ELASTICSEARCH_ADDRESSES_ADDRESS_1", "http://es:9200")
+	t.Setenv("ORDERAPI_SOFT_DELETE_RETENTION", "48h")
+	// Only the keys of the config are set, other map entries are not added
+	t.Setenv("ORDERAPI_SERVER_PORT_OTHER", ":9001")
+
+	config := DefaultConfig()
+	if err := applyEnv(reflect.ValueOf(&config).Elem(), EnvPrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := DefaultConfig()
+	expected.Database.Connection = "mongodb://db:27017"
+	expected.Server.Port["orderAPI"] = ":9000"
+	expected.Elasticsearch.Addresses["Address 1"] = "http://es:9200"
+	expected.SoftDelete.Retention = 48 * time.Hour
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestApplyEnvRejectsInvalidDurations(t *testing.T) {
+	t.Setenv("ORDERAPI_IDEMPOTENCY_TTL", "one day")
+
+	config := DefaultConfig()
+	err := applyEnv(reflect.ValueOf(&config).Elem(), EnvPrefix)
+	if err == nil || !strings.Contains(err.Error(), "ORDERAPI_IDEMPOTENCY_TTL") {
+		t.Errorf("expected the invalid duration to be rejected, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(config *Config)
+		problems []string
+	}{
+		{
+			name:   "valid config",
+			change: func(config *Config) {},
+		},
+		{
+			name: "defaults without connection and address",
+			change: func(config *Config) {
+				*config = DefaultConfig()
+			},
+			problems: []string{"database.connection", "elasticsearch.addresses"},
+		},
+		{
+			name: "invalid mongo uri",
+			change: func(config *Config) {
+				config.Database.Connection = "localhost:27017"
+			},
+			problems: []string{"not a valid mongo uri"},
+		},
+		{
+			name: "missing collection",
+			change: func(config *Config) {
+				config.Database.AuditCollectionName = ""
+			},
+			problems: []string{"database.auditCollectionName is missing"},
+		},
+		{
+			name: "invalid elasticsearch address",
+			change: func(config *Config) {
+				config.Elasticsearch.Addresses["Address 1"] = "localhost:9200"
+			},
+			problems: []string{"not a valid http url"},
+		},
+		{
+			name: "index named like the alias",
+			change: func(config *Config) {
+				config.Elasticsearch.IndexName["Order"] = config.Elasticsearch.AliasName["Order"]
+			},
+			problems: []string{"cannot be the same"},
+		},
+		{
+			name: "invalid port",
+			change: func(config *Config) {
+				config.Server.Port["orderAPI"] = "8011"
+			},
+			problems: []string{"server.port.orderAPI"},
+		},
+		{
+			name: "durations which are not positive",
+			change: func(config *Config) {
+				config.SoftDelete.Retention = 0
+				config.SoftDelete.PurgeInterval = -time.Hour
+				config.Idempotency.TTL = 0
+			},
+			problems: []string{"softDelete.retention", "softDelete.purgeInterval", "idempotency.ttl"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			test.change(&config)
+
+			err := config.Validate()
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected a valid config, got %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected the config to be rejected")
+			}
+			// Every problem is reported in one error
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("expected %q in %q", problem, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	valid := write("valid.yaml", `
+database:
+  connection: mongodb://localhost:27017
+elasticsearch:
+  addresses:
+    Address 1: http://localhost:9200
+softDelete:
+  retention: 72h
+`)
+	misspelled := write("misspelled.yaml", `
+database:
+  conection: mongodb://localhost:27017
+`)
+	invalidJSON := write("invalid.json", `{"database": {"connection": "mongodb://localhost:27017"`)
+
+	config, err := LoadConfig("test", valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Settings which are not in the file keep their defaults
+	if config.SoftDelete.Retention != 72*time.Hour || config.SoftDelete.PurgeInterval != time.Hour {
+		t.Errorf("expected the retention of the file and the default purge interval, got %+v", config.SoftDelete)
+	}
+
+	// The environment overrides the file
+	t.Setenv("ORDERAPI_SOFT_DELETE_RETENTION", "24h")
+	if config, err = LoadConfig("test", valid); err != nil {
+		t.Fatal(err)
+	}
+	if config.SoftDelete.Retention != 24*time.Hour {
+		t.Errorf("expected the retention of the environment, got %v", config.SoftDelete.Retention)
+	}
+
+	for _, path := range []string{misspelled, invalidJSON, filepath.Join(dir, "missing.yaml")} {
+		if _, err := LoadConfig("test", path); err == nil {
+			t.Errorf("%s: expected the config to be rejected", filepath.Base(path))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Actions of audit entries
+const (
+	AuditActionCreate        = "create"
+	AuditActionUpdate        = "update"
+	AuditActionStatusChange  = "status_change"
+	AuditActionDelete        = "delete"
+	AuditActionRestore       = "restore"
+	AuditActionDeleteByQuery = "delete_by_query"
+	AuditActionPurge         = "purge"
+)
+
+// AuditActorSystem is the actor of changes made by background jobs
+const AuditActorSystem = "system"
+
+// AuditEntry records one change of an order, entries are only inserted and never changed
+type AuditEntry struct {
+	ID        string                 `json:"id" bson:"_id"`
+	OrderID   string                 `json:"orderId" bson:"orderId"`
+	Action    string                 `json:"action" bson:"action"`
+	Actor     string                 `json:"actor" bson:"actor"`
+	Timestamp time.Time              `json:"timestamp" bson:"timestamp"`
+	Changes   map[string]AuditChange `json:"changes" bson:"changes"`
+}
+
+// AuditChange holds the value of a field before and after the change, nil means the field did not exist
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+func NewAuditEntry(orderID string, action string, actor string, changes map[string]AuditChange) AuditEntry {
+	return AuditEntry{
+		ID:        uuid.New().String(),
+		OrderID:   orderID,
+		Action:    action,
+		Actor:     actor,
+		Timestamp: time.Now(),
+		Changes:   changes,
+	}
+}
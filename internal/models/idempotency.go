@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Statuses of idempotency keys, keys saved before statuses were added have no status and are completed
+const (
+	IdempotencyStatusInProgress = "in_progress"
+	IdempotencyStatusCompleted  = "completed"
+)
+
+// IdempotencyKey keeps the order created by a request with an Idempotency-Key header, a retried request
+// with the same key returns the same order. The key is in progress until the order is inserted.
+// Keys are removed by MongoDB after their time to live.
+type IdempotencyKey struct {
+	Key         string    `json:"key" bson:"_id"`
+	RequestHash string    `json:"requestHash" bson:"requestHash"`
+	OrderID     string    `json:"orderId" bson:"orderId"`
+	Status      string    `json:"status" bson:"status,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+func NewIdempotencyKey(key string, requestHash string, orderID string) IdempotencyKey {
+	return IdempotencyKey{
+		Key:         key,
+		RequestHash: requestHash,
+		OrderID:     orderID,
+		Status:      IdempotencyStatusInProgress,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// IsCompleted reports whether the order of the key is inserted
+func (k *IdempotencyKey) IsCompleted() bool {
+	return k.Status != IdempotencyStatusInProgress
+}
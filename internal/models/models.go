@@ -3,17 +3,38 @@ package models
 import "time"
 
 type Order struct {
-	ID            string `json:"id,omitempty" bson:"_id"`
-	UserID        string `json:"userId,omitempty" bson:"userId"`
-	Status        string `json:"status,omitempty" bson:"status"`
-	City          string `json:"city,omitempty" bson:"city"`
-	AddressDetail string `json:"addressDetail,omitempty" bson:"addressDetail"`
-	Product       []struct {
-		Name     string  `json:"name" bson:"name"`
-		Quantity int     `json:"quantity" bson:"quantity"`
-		Price    float64 `json:"price" bson:"price"`
-	} `json:"product,omitempty" bson:"product"`
-	Total     float64   `json:"total,omitempty" bson:"total"`
-	CreatedAt time.Time `json:"createdAt,omitempty" bson:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt"`
+	ID            string        `json:"id,omitempty" bson:"_id"`
+	UserID        string        `json:"userId,omitempty" bson:"userId"`
+	Status        string        `json:"status,omitempty" bson:"status"`
+	City          string        `json:"city,omitempty" bson:"city"`
+	AddressDetail string        `json:"addressDetail,omitempty" bson:"addressDetail"`
+	Product       []ProductLine `json:"product,omitempty" bson:"product"`
+	Total         float64       `json:"total,omitempty" bson:"total"`
+	CreatedAt     time.Time     `json:"createdAt,omitempty" bson:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt,omitempty" bson:"updatedAt"`
+	// Time of every status transition, keyed by status
+	StatusHistory map[string]time.Time `json:"statusHistory,omitempty" bson:"statusHistory"`
+	// Soft deleted orders keep the time of deletion until they are purged
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	// Version is increased by every change, orders created before versioning are version 0
+	Version int64 `json:"version" bson:"version"`
+}
+
+type ProductLine struct {
+	Name     string  `json:"name" bson:"name"`
+	Quantity int     `json:"quantity" bson:"quantity"`
+	Price    float64 `json:"price" bson:"price"`
+}
+
+// CalculateTotal sets Total from the price and quantity of every product line
+func (o *Order) CalculateTotal() {
+	o.Total = 0
+	for _, product := range o.Product {
+		o.Total += product.Price * float64(product.Quantity)
+	}
+}
+
+// IsDeleted reports whether the order is soft deleted
+func (o *Order) IsDeleted() bool {
+	return o.DeletedAt != nil
 }
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	StatusCreated   = "created"
+	StatusConfirmed = "confirmed"
+	StatusPreparing = "preparing"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+	StatusReturned  = "returned"
+)
+
+// StatusTransitions lists the statuses an order can move to from every status
+var StatusTransitions = map[string][]string{
+	StatusCreated:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusPreparing, StatusCancelled},
+	StatusPreparing: {StatusShipped, StatusCancelled},
+	StatusShipped:   {StatusDelivered},
+	StatusDelivered: {StatusReturned},
+	StatusCancelled: {},
+	StatusReturned:  {},
+}
+
+func IsValidStatus(status string) bool {
+	_, ok := StatusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an order can move from one status to another. Orders saved before statuses
+// were defined can move to any status.
+func CanTransition(from string, to string) bool {
+	if !IsValidStatus(to) {
+		return false
+	}
+
+	next, ok := StatusTransitions[from]
+	if !ok {
+		return true
+	}
+
+	for _, status := range next {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ChangeStatus moves the order to the status and records the time of the transition
+func (o *Order) ChangeStatus(status string, at time.Time) error {
+	if !IsValidStatus(status) {
+		return fmt.Errorf("status %q is not valid", status)
+	}
+
+	if !CanTransition(o.Status, status) {
+		return fmt.Errorf("order cannot move from %q to %q", o.Status, status)
+	}
+
+	o.Status = status
+	if o.StatusHistory == nil {
+		o.StatusHistory = make(map[string]time.Time)
+	}
+	o.StatusHistory[status] = at
+
+	return nil
+}
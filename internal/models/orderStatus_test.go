@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{StatusCreated, StatusConfirmed, true},
+		{StatusCreated, StatusCancelled, true},
+		{StatusCreated, StatusShipped, false},
+		{StatusConfirmed, StatusPreparing, true},
+		{StatusPreparing, StatusShipped, true},
+		{StatusShipped, StatusDelivered, true},
+		{StatusShipped, StatusCancelled, false},
+		{StatusDelivered, StatusReturned, true},
+		{StatusDelivered, StatusCreated, false},
+		{StatusCancelled, StatusCreated, false},
+		{StatusReturned, StatusDelivered, false},
+		{StatusCreated, StatusCreated, false},
+		// Orders saved before statuses were defined can move to any status
+		{"", StatusShipped, true},
+		{"old status", StatusDelivered, true},
+		{"", "unknown", false},
+		{StatusCreated, "unknown", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+" to "+test.to, func(t *testing.T) {
+			if actual := CanTransition(test.from, test.to); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestChangeStatusRecordsTheTransition(t *testing.T) {
+	at := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	order := Order{Status: StatusCreated}
+
+	if err := order.ChangeStatus(StatusConfirmed, at); err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusConfirmed || !order.StatusHistory[StatusConfirmed].Equal(at) {
+		t.Errorf("expected %s at %v, got %s and %v", StatusConfirmed, at, order.Status, order.StatusHistory)
+	}
+
+	if err := order.ChangeStatus(StatusDelivered, at); err == nil {
+		t.Errorf("expected an error from %s to %s", StatusConfirmed, StatusDelivered)
+	}
+	if order.Status != StatusConfirmed {
+		t.Errorf("expected the status to stay %s, got %s", StatusConfirmed, order.Status)
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	OutboxOperationIndex  = "index"
+	OutboxOperationDelete = "delete"
+
+	OutboxStatusPending = "pending"
+	OutboxStatusFailed  = "failed"
+)
+
+// OutboxEvent is written in the same transaction as an order change and applied to Elasticsearch later
+type OutboxEvent struct {
+	ID            string    `json:"id" bson:"_id"`
+	OrderID       string    `json:"orderId" bson:"orderId"`
+	Operation     string    `json:"operation" bson:"operation"`
+	Status        string    `json:"status" bson:"status"`
+	Attempts      int       `json:"attempts" bson:"attempts"`
+	LastError     string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" bson:"nextAttemptAt"`
+}
+
+func NewOutboxEvent(orderID string, operation string) OutboxEvent {
+	now := time.Now()
+	return OutboxEvent{
+		ID:            uuid.New().String(),
+		OrderID:       orderID,
+		Operation:     operation,
+		Status:        OutboxStatusPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+}
@@ -3,6 +3,7 @@ package models
 type JSONSuccessResultData struct {
 	TotalItemCount int         `json:"total_item_count"`
 	Data           interface{} `json:"data"`
+	NextCursor     string      `json:"next_cursor,omitempty"`
 }
 
 type JSONSuccessResultId struct {
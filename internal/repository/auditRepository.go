@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"GenericEndpoint/internal/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"time"
+)
+
+// GetAuditEntries method => to list the audit entries of an order, the last change is the first
+func (r *Repository) GetAuditEntries(orderID string, limit int64, offset int64) ([]models.AuditEntry, int64, error) {
+	var entries []models.AuditEntry
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	filter := bson.M{"orderId": orderID}
+
+	total, err := r.AuditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+
+	result, err := r.AuditCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := result.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// findDocuments reads the orders as documents, so the audit trail keeps every stored field
+func (r *Repository) findDocuments(ctx context.Context, ids []string) (map[string]bson.M, error) {
+	result, err := r.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []bson.M
+	if err := result.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]bson.M, len(documents))
+	for _, document := range documents {
+		if id, ok := document["_id"].(string); ok {
+			byID[id] = document
+		}
+	}
+
+	return byID, nil
+}
+
+// writeAudit inserts an entry for every order which is changed, orders without a changed field are skipped
+func (r *Repository) writeAudit(ctx context.Context, ids []string, action string, actor string, before map[string]bson.M, after map[string]bson.M) error {
+	entries := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		changes := diffDocuments(before[id], after[id])
+		if len(changes) == 0 {
+			continue
+		}
+		entries = append(entries, models.NewAuditEntry(id, action, actor, changes))
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	_, err := r.AuditCollection.InsertMany(ctx, entries)
+	return err
+}
+
+// diffDocuments returns the top-level fields which are added, removed or changed
+func diffDocuments(before bson.M, after bson.M) map[string]models.AuditChange {
+	changes := make(map[string]models.AuditChange)
+
+	for field, value := range before {
+		if afterValue, ok := after[field]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changes[field] = models.AuditChange{Before: value, After: afterValue}
+		}
+	}
+
+	for field, value := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = models.AuditChange{Before: nil, After: value}
+		}
+	}
+
+	return changes
+}
+
+// EnsureIndexes method => to create the indexes used by the repository, existing indexes are not changed
+func (r *Repository) EnsureIndexes() error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.AuditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+	return err
+}
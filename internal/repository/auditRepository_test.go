@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"GenericEndpoint/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   bson.M
+		after    bson.M
+		expected map[string]models.AuditChange
+	}{
+		{
+			name:     "same documents",
+			before:   bson.M{"status": "created", "total": 10.0},
+			after:    bson.M{"status": "created", "total": 10.0},
+			expected: map[string]models.AuditChange{},
+		},
+		{
+			name:   "changed field",
+			before: bson.M{"status": "created", "total": 10.0},
+			after:  bson.M{"status": "confirmed", "total": 10.0},
+			expected: map[string]models.AuditChange{
+				"status": {Before: "created", After: "confirmed"},
+			},
+		},
+		{
+			name:   "added and removed fields",
+			before: bson.M{"status": "created", "city": "Ankara"},
+			after:  bson.M{"status": "created", "deletedAt": "2023-05-01"},
+			expected: map[string]models.AuditChange{
+				"city":      {Before: "Ankara", After: nil},
+				"deletedAt": {Before: nil, After: "2023-05-01"},
+			},
+		},
+		{
+			name:   "nested values are compared deeply",
+			before: bson.M{"product": bson.A{bson.M{"name": "Pen", "quantity": 1}}},
+			after:  bson.M{"product": bson.A{bson.M{"name": "Pen", "quantity": 2}}},
+			expected: map[string]models.AuditChange{
+				"product": {Before: bson.A{bson.M{"name": "Pen", "quantity": 1}}, After: bson.A{bson.M{"name": "Pen", "quantity": 2}}},
+			},
+		},
+		{
+			name:   "inserted document",
+			before: nil,
+			after:  bson.M{"status": "created"},
+			expected: map[string]models.AuditChange{
+				"status": {Before: nil, After: "created"},
+			},
+		},
+		{
+			name:   "deleted document",
+			before: bson.M{"status": "created"},
+			after:  nil,
+			expected: map[string]models.AuditChange{
+				"status": {Before: "created", After: nil},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := diffDocuments(test.before, test.after); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
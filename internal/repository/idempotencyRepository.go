@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"GenericEndpoint/internal/models"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// IdempotencyRepository keeps the idempotency keys of created orders
+type IdempotencyRepository struct {
+	Collection *mongo.Collection
+}
+
+func NewIdempotencyRepository(mongoCollection *mongo.Collection) *IdempotencyRepository {
+	repository := &IdempotencyRepository{Collection: mongoCollection}
+	return repository
+}
+
+// EnsureIndexes method => to create the TTL index which removes keys older than the ttl
+func (r *IdempotencyRepository) EnsureIndexes(ttl time.Duration) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	return err
+}
+
+// Reserve method => to save the key if it is not used, the saved key is returned if it is used before.
+// The unique _id decides between concurrent requests with the same key.
+func (r *IdempotencyRepository) Reserve(key models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.InsertOne(ctx, key)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyKey
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": key.Key}).Decode(&existing); err != nil {
+		// The key expired after the insert failed, the request can be retried
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("idempotency key expired while it was read")
+		}
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// Complete method => to mark the key as completed after its order is inserted
+func (r *IdempotencyRepository) Complete(key string) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"status": models.IdempotencyStatusCompleted}})
+	return err
+}
+
+// TakeOver method => to give a key which is in progress to another order, false is returned if the key is
+// changed by another request after it is read
+func (r *IdempotencyRepository) TakeOver(existing models.IdempotencyKey, orderID string) (bool, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	result, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": existing.Key, "status": models.IdempotencyStatusInProgress, "orderId": existing.OrderID},
+		bson.M{"$set": bson.M{"orderId": orderID, "createdAt": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// Delete method => to remove the key, so a request whose order is not created can be retried with it
+func (r *IdempotencyRepository) Delete(key string) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -4,24 +4,29 @@ import (
 	"GenericEndpoint/internal/models"
 	"context"
 	"errors"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
+// ErrVersionConflict is returned when the order is changed by another request after it is read
+var ErrVersionConflict = errors.New("order is changed by another request")
+
 type Repository struct {
-	Collection *mongo.Collection
+	Collection       *mongo.Collection
+	OutboxCollection *mongo.Collection
+	AuditCollection  *mongo.Collection
 }
 
-func NewRepository(mongoCollection *mongo.Collection) *Repository {
-	repository := &Repository{Collection: mongoCollection}
+func NewRepository(mongoCollection *mongo.Collection, outboxCollection *mongo.Collection, auditCollection *mongo.Collection) *Repository {
+	repository := &Repository{Collection: mongoCollection, OutboxCollection: outboxCollection, AuditCollection: auditCollection}
 	return repository
 }
 
-// GetAll Method => to list every order
-func (r *Repository) GetAll() ([]models.Order, error) {
-	var order models.Order
+// GetAll Method => to list every order, soft deleted orders are listed only if they are included
+func (r *Repository) GetAll(includeDeleted bool) ([]models.Order, error) {
 	var orders []models.Order
 
 	// open connection
@@ -29,13 +34,20 @@ func (r *Repository) GetAll() ([]models.Order, error) {
 	defer cancel()
 
 	//We can think of "Cursor" like a request. We pull the data from the database with the "Next" command. (C# => IQueryable)
-	result, err := r.Collection.Find(ctx, bson.M{})
+	filter := bson.M{"deletedAt": nil}
+	if includeDeleted {
+		filter = bson.M{}
+	}
+
+	result, err := r.Collection.Find(ctx, filter)
 
 	if err != nil {
 		return nil, err
 	}
 
 	for result.Next(ctx) {
+		// A new value for every document, fields missing in the document would keep the previous values otherwise
+		var order models.Order
 		if err := result.Decode(&order); err != nil {
 			return nil, err
 		}
@@ -46,7 +58,6 @@ func (r *Repository) GetAll() ([]models.Order, error) {
 }
 
 func (r *Repository) GetOrdersWithFilter(filter bson.M, findOptions *options.FindOptions) ([]models.Order, error) {
-	var order models.Order
 	var orders []models.Order
 
 	// open connection
@@ -61,6 +72,8 @@ func (r *Repository) GetOrdersWithFilter(filter bson.M, findOptions *options.Fin
 	}
 
 	for result.Next(ctx) {
+		// A new value for every document, fields missing in the document would keep the previous values otherwise
+		var order models.Order
 		if err := result.Decode(&order); err != nil {
 			return nil, err
 		}
@@ -70,32 +83,483 @@ func (r *Repository) GetOrdersWithFilter(filter bson.M, findOptions *options.Fin
 	return orders, nil
 }
 
+// AggregateOrders method => to run an aggregation pipeline on orders, documents are returned as they are
+func (r *Repository) AggregateOrders(pipeline mongo.Pipeline) ([]bson.Raw, error) {
+	var documents []bson.Raw
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	result, err := r.Collection.Aggregate(ctx, pipeline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for result.Next(ctx) {
+		// Current is reused by the cursor, so the document is copied
+		documents = append(documents, append(bson.Raw{}, result.Current...))
+	}
+
+	return documents, result.Err()
+}
+
+// GetOrdersAfterID method => to list orders matching the filter in _id order, the list starts after afterID (empty string for the first batch)
+func (r *Repository) GetOrdersAfterID(filter bson.M, afterID string, limit int64) ([]models.Order, error) {
+	var orders []models.Order
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	result, err := r.Collection.Find(ctx, bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": afterID}}}}, findOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// GetUpdateTimesAfterID method => to list only the _id and updatedAt of orders in _id order, the list starts after afterID
+func (r *Repository) GetUpdateTimesAfterID(afterID string, limit int64) ([]models.Order, error) {
+	var orders []models.Order
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"_id": 1, "updatedAt": 1})
+	result, err := r.Collection.Find(ctx, bson.M{"_id": bson.M{"$gt": afterID}}, findOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// CountOrdersWithFilter method => to count every order matching the filter
+func (r *Repository) CountOrdersWithFilter(filter bson.M) (int64, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	return r.Collection.CountDocuments(ctx, filter)
+}
+
+// FindByID method => to get an order, mongo.ErrNoDocuments is returned if it does not exist
+func (r *Repository) FindByID(id string) (models.Order, error) {
+	var order models.Order
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&order); err != nil {
+		return models.Order{}, err
+	}
+
+	return order, nil
+}
+
 // Insert method => create new order
-func (r *Repository) Insert(order models.Order) (bool, error) {
+func (r *Repository) Insert(order models.Order, actor string) (bool, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	err := r.withOutbox(ctx, []string{order.ID}, models.OutboxOperationIndex, models.AuditActionCreate, actor, func(sessionContext mongo.SessionContext) error {
+		// Driver errors keep their labels, so transient errors are retried and duplicate keys can be told apart
+		if _, err := r.Collection.InsertOne(sessionContext, order); err != nil {
+			return fmt.Errorf("failed to add: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// InsertMany method => create many orders, the failed orders are returned by their index. Ordered insert stops at
+// the first failed order like MongoDB, so the orders after it are returned as failed too.
+// Orders are inserted with their outbox events and audit entries in one transaction. A write error aborts the
+// transaction, so it is retried without the failed orders until the rest is inserted.
+func (r *Repository) InsertMany(orders []models.Order, ordered bool, actor string) (map[int]string, error) {
+	failed := make(map[int]string)
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	// Indexes of the orders which are not failed yet
+	pending := make([]int, 0, len(orders))
+	for i := range orders {
+		pending = append(pending, i)
+	}
+
+	for len(pending) > 0 {
+		ids := make([]string, 0, len(pending))
+		documents := make([]interface{}, 0, len(pending))
+		for _, i := range pending {
+			ids = append(ids, orders[i].ID)
+			documents = append(documents, orders[i])
+		}
+
+		err := r.withOutbox(ctx, ids, models.OutboxOperationIndex, models.AuditActionCreate, actor, func(sessionContext mongo.SessionContext) error {
+			_, err := r.Collection.InsertMany(sessionContext, documents, options.InsertMany().SetOrdered(ordered))
+			return err
+		})
+
+		var bulkWriteException mongo.BulkWriteException
+		if !errors.As(err, &bulkWriteException) || len(bulkWriteException.WriteErrors) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		first := len(pending)
+		for _, writeError := range bulkWriteException.WriteErrors {
+			failed[pending[writeError.Index]] = writeError.Message
+			if writeError.Index < first {
+				first = writeError.Index
+			}
+		}
+
+		if ordered {
+			for _, i := range pending[first+1:] {
+				failed[i] = "not inserted because a previous order failed"
+			}
+			pending = pending[:first]
+			continue
+		}
+
+		remaining := make([]int, 0, len(pending))
+		for _, i := range pending {
+			if _, ok := failed[i]; !ok {
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
+	}
+
+	return failed, nil
+}
+
+// Delete method => soft delete order, the order is kept with deletedAt until it is purged.
+// ErrVersionConflict is returned if the order does not have the version.
+func (r *Repository) Delete(id string, version int64, actor string) (bool, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	// Soft deleted orders stay in the index with deletedAt, so they can be listed with include_deleted
+	err := r.withOutbox(ctx, []string{id}, models.OutboxOperationIndex, models.AuditActionDelete, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.UpdateOne(sessionContext,
+			bson.M{"_id": id, "deletedAt": nil, "version": versionFilter(version)},
+			bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}, "$inc": bson.M{"version": 1}})
+
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount <= 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Restore method => remove deletedAt of a soft deleted order
+func (r *Repository) Restore(id string, actor string) (bool, error) {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	err := r.withOutbox(ctx, []string{id}, models.OutboxOperationIndex, models.AuditActionRestore, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.UpdateOne(sessionContext,
+			bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}},
+			bson.M{"$unset": bson.M{"deletedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}, "$inc": bson.M{"version": 1}})
+
+		if err != nil {
+			return fmt.Errorf("failed to restore: %w", err)
+		}
+		// The order is restored or purged by another request after it is read
+		if result.MatchedCount <= 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetDeletedBefore method => to list the ids of orders which are soft deleted before the time
+func (r *Repository) GetDeletedBefore(before time.Time, limit int64) ([]string, error) {
+	findOptions := options.Find().SetLimit(limit).SetProjection(bson.M{"_id": 1})
+
+	orders, err := r.GetOrdersWithFilter(bson.M{"deletedAt": bson.M{"$lt": before}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+
+	return ids, nil
+}
+
+// SoftDeleteMany method => soft delete the orders with the ids which match the filter and are not deleted, the number
+// of deleted orders is returned. The action tells the audit trail why the orders are deleted.
+func (r *Repository) SoftDeleteMany(ids []string, filter bson.M, action string, actor string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	var deleted int64
+	err := r.withOutbox(ctx, ids, models.OutboxOperationIndex, action, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.UpdateMany(sessionContext,
+			bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": ids}, "deletedAt": nil}}},
+			bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return err
+		}
+
+		deleted = result.ModifiedCount
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteMany method => delete the orders with the ids which match the filter, the number of deleted orders is returned.
+// The action tells the audit trail why the orders are deleted.
+func (r *Repository) DeleteMany(ids []string, filter bson.M, action string, actor string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var deleted int64
+	err := r.withOutbox(ctx, ids, models.OutboxOperationDelete, action, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.DeleteMany(sessionContext, bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": ids}}}})
+		if err != nil {
+			return err
+		}
+
+		deleted = result.DeletedCount
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// Replace method => replace the whole order if it has the version, the order is saved with its own version.
+// ErrVersionConflict is returned if the order does not have the version.
+func (r *Repository) Replace(order models.Order, version int64, actor string) (bool, error) {
 	// open connection
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	result, err := r.Collection.InsertOne(ctx, order)
+	err := r.withOutbox(ctx, []string{order.ID}, models.OutboxOperationIndex, models.AuditActionUpdate, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.ReplaceOne(sessionContext, bson.M{"_id": order.ID, "version": versionFilter(version)}, order)
 
-	if result.InsertedID == nil || err != nil {
-		return false, errors.New("failed to add")
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount <= 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+
+	if err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
 
-// Delete method => delete order
-func (r *Repository) Delete(id string) (bool, error) {
+// Update method => set the given fields of an order if it has the version, the action tells the audit trail
+// what is changed. ErrVersionConflict is returned if the order does not have the version.
+func (r *Repository) Update(id string, fields bson.M, version int64, action string, actor string) (bool, error) {
 	// open connection
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	result, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	err := r.withOutbox(ctx, []string{id}, models.OutboxOperationIndex, action, actor, func(sessionContext mongo.SessionContext) error {
+		result, err := r.Collection.UpdateOne(sessionContext,
+			bson.M{"_id": id, "version": versionFilter(version)},
+			bson.M{"$set": fields, "$inc": bson.M{"version": 1}})
+
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount <= 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
 
-	if result.DeletedCount <= 0 || err != nil {
-		return false, errors.New("failed to delete")
+	if err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
+
+// versionFilter matches the version, orders created before versioning have no version field and are version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+
+	return version
+}
+
+// withOutbox runs the change of the orders and writes their outbox events and audit entries in one transaction,
+// so Elasticsearch and the audit trail are updated for every change which is committed to MongoDB
+func (r *Repository) withOutbox(ctx context.Context, orderIDs []string, operation string, action string, actor string, change func(sessionContext mongo.SessionContext) error) error {
+	session, err := r.Collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		before, err := r.findDocuments(sessionContext, orderIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := change(sessionContext); err != nil {
+			return nil, err
+		}
+
+		after, err := r.findDocuments(sessionContext, orderIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.writeAudit(sessionContext, orderIDs, action, actor, before, after); err != nil {
+			return nil, err
+		}
+
+		events := make([]interface{}, 0, len(orderIDs))
+		for _, orderID := range orderIDs {
+			events = append(events, models.NewOutboxEvent(orderID, operation))
+		}
+
+		_, err = r.OutboxCollection.InsertMany(sessionContext, events)
+		return nil, err
+	})
+
+	return err
+}
+
+// GetPendingOutboxEvents method => to list events which are ready to be applied, oldest first
+func (r *Repository) GetPendingOutboxEvents(limit int64) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": models.OutboxStatusPending, "nextAttemptAt": bson.M{"$lte": time.Now()}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetLimit(limit)
+
+	result, err := r.OutboxCollection.Find(ctx, filter, findOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteOutboxEvent method => remove an applied event
+func (r *Repository) DeleteOutboxEvent(id string) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.OutboxCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// RetryOutboxEvent method => save the failed attempt of an event, status is failed when it will not be retried
+func (r *Repository) RetryOutboxEvent(event models.OutboxEvent) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.OutboxCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{
+		"status":        event.Status,
+		"attempts":      event.Attempts,
+		"lastError":     event.LastError,
+		"nextAttemptAt": event.NextAttemptAt,
+	}})
+	return err
+}
+
+// WatchOrders method => to open a change stream on orders, the stream starts after the resume token if it is given.
+// Updated documents are looked up, so every event except delete has the current order in fullDocument.
+func (r *Repository) WatchOrders(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	changeStreamOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != nil {
+		changeStreamOptions.SetStartAfter(resumeToken)
+	}
+
+	return r.Collection.Watch(ctx, mongo.Pipeline{}, changeStreamOptions)
+}
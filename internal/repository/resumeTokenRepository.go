@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ResumeTokenRepository keeps the last processed change stream token of every consumer
+type ResumeTokenRepository struct {
+	Collection *mongo.Collection
+}
+
+type resumeToken struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func NewResumeTokenRepository(mongoCollection *mongo.Collection) *ResumeTokenRepository {
+	repository := &ResumeTokenRepository{Collection: mongoCollection}
+	return repository
+}
+
+// Get method => to find the token of the consumer, nil is returned if it was never saved
+func (r *ResumeTokenRepository) Get(name string) (bson.Raw, error) {
+	var token resumeToken
+
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	err := r.Collection.FindOne(ctx, bson.M{"_id": name}).Decode(&token)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token.Token, nil
+}
+
+// Save method => to create or replace the token of the consumer
+func (r *ResumeTokenRepository) Save(name string, token bson.Raw) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.ReplaceOne(ctx, bson.M{"_id": name}, resumeToken{Name: name, Token: token, UpdatedAt: time.Now()}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Delete method => to remove the token, the consumer starts from the current time next time
+func (r *ResumeTokenRepository) Delete(name string) error {
+	// open connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
@@ -1,7 +1,66 @@
 package main
 
-import "GenericEndpoint/cmd"
+import (
+	"GenericEndpoint/cmd"
+	"GenericEndpoint/internal/configs"
+	"flag"
+	"fmt"
+	"github.com/labstack/gommon/log"
+	"os"
+)
 
 func main() {
-	cmd.StartOrderAPI()
+	// Flags are given before the subcommand, e.g. "-env prod indexer"
+	env := flag.String("env", os.Getenv("APP_ENV"), "environment of the config, APP_ENV is used when it is not given")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "config file (yaml or json), config/<env>.yaml is used when it is not given")
+	flag.Usage = usage
+	flag.Parse()
+
+	// Unknown subcommands are rejected before the config is loaded, a typo must not start the API
+	args := flag.Args()
+	if len(args) > 0 && !knownSubcommand(args[0]) {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown subcommand %q\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Invalid config stops the service before it connects to anything
+	config, err := configs.LoadConfig(*env, *configFile)
+	if err != nil {
+		log.Fatalf("Config cannot be loaded: %v", err)
+	}
+
+	// Subcommands run a tool instead of the API
+	if len(args) > 0 {
+		switch args[0] {
+		case "indexer":
+			cmd.StartOrderIndexer(config)
+			return
+		case "reindex":
+			cmd.StartOrderReindex(config)
+			return
+		case "check":
+			// "check --repair" fixes the differences
+			cmd.StartOrderCheck(config, len(args) > 1 && args[1] == "--repair")
+			return
+		}
+	}
+
+	cmd.StartOrderAPI(config)
+}
+
+func knownSubcommand(name string) bool {
+	switch name {
+	case "indexer", "reindex", "check":
+		return true
+	}
+	return false
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [indexer | reindex | check [--repair]]\n", os.Args[0])
+	fmt.Fprintln(out, "The API is started when no subcommand is given.")
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
 }
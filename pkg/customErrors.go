@@ -1,5 +1,10 @@
 package pkg
 
+import (
+	"fmt"
+	"net/http"
+)
+
 type InternalServerError struct {
 	Message string
 }
@@ -18,12 +23,48 @@ func (e *NotFoundError) Error() string {
 
 type BadRequestError struct {
 	Message string
+	// Fields which are not valid, empty for other bad requests
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError tells which rule a field of the request breaks, the field is the path of its json names
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 func (e *BadRequestError) Error() string {
 	return e.Message
 }
 
+// PreconditionFailedError is returned when the If-Match version is not the current version of the order
+type PreconditionFailedError struct {
+	Message string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return e.Message
+}
+
+// PreconditionRequiredError is returned when a change is requested without If-Match
+type PreconditionRequiredError struct {
+	Message string
+}
+
+func (e *PreconditionRequiredError) Error() string {
+	return e.Message
+}
+
+// ConflictError is returned when the request conflicts with a previous request
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
 type ClientSideError struct {
 	Message string
 }
@@ -31,3 +72,21 @@ type ClientSideError struct {
 func (e *ClientSideError) Error() string {
 	return e.Message
 }
+
+// ElasticsearchError is an error response of Elasticsearch, StatusCode is 0 when Elasticsearch cannot be reached
+type ElasticsearchError struct {
+	StatusCode int
+	Type       string
+	Reason     string
+}
+
+func (e *ElasticsearchError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("elasticsearch is not reachable: %v", e.Reason)
+	}
+	if e.Type == "" {
+		return fmt.Sprintf("[%d %s] %v", e.StatusCode, http.StatusText(e.StatusCode), e.Reason)
+	}
+
+	return fmt.Sprintf("[%d %s] %s: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Type, e.Reason)
+}
@@ -1,34 +1,139 @@
 package pkg
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
 )
 
+// Codes of problem documents, clients can rely on them while messages change
+const (
+	CodeBadRequest           = "bad_request"
+	CodeClientError          = "client_error"
+	CodeValidationFailed     = "validation_failed"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeDuplicateKey         = "duplicate_key"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeTimeout              = "timeout"
+	CodeDatabaseUnavailable  = "database_unavailable"
+	CodeSearchUnavailable    = "search_unavailable"
+	CodeSearchFailed         = "search_failed"
+	CodeInternal             = "internal_error"
+)
+
+// ProblemContentType is the media type of problem documents (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is the body of every error response. Type is about:blank, so Title is the text of the status
+// and Code tells the kind of the error.
+type ProblemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// ErrorHandlerMiddleware writes the errors returned by handlers as problem documents. The request id is taken
+// from the X-Request-ID header of the response, so the RequestID middleware must run before the handler.
 func ErrorHandlerMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		err := next(c)
-		if err != nil {
-			switch err.(type) {
-			case *NotFoundError:
-				return c.JSON(http.StatusNotFound, NotFoundError{
-					Message: fmt.Sprintf("NotFoundError: %v", err.Error()),
-				})
-			case *BadRequestError:
-				return c.JSON(http.StatusBadRequest, BadRequestError{
-					Message: fmt.Sprintf("BadRequestError: %v", err.Error()),
-				})
-			case *ClientSideError:
-				return c.JSON(http.StatusBadRequest, ClientSideError{
-					Message: fmt.Sprintf("ClientSideError: %v", err.Error()),
-				})
-			default:
-				return c.JSON(http.StatusInternalServerError, InternalServerError{
-					Message: fmt.Sprintf("StatusInternalServerError: %v", err.Error()),
-				})
-			}
+		if err == nil || c.Response().Committed {
+			return err
+		}
+
+		problem := NewProblem(err)
+		problem.Instance = c.Request().URL.Path
+		problem.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+		if problem.Status >= http.StatusInternalServerError {
+			c.Logger().Errorf("%s {%s}: %v", problem.Code, problem.RequestID, err)
+		} else {
+			c.Logger().Warnf("%s {%s}: %v", problem.Code, problem.RequestID, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, ProblemContentType)
+		return c.JSON(problem.Status, problem)
+	}
+}
+
+// NewProblem maps the error to the status, code and detail of its problem document. Details of unexpected
+// errors are not returned to clients, they are only logged.
+func NewProblem(err error) ProblemDetails {
+	status, code, detail := http.StatusInternalServerError, CodeInternal, "Something went wrong!"
+	var fieldErrors []FieldError
+
+	var (
+		badRequestError           *BadRequestError
+		clientSideError           *ClientSideError
+		notFoundError             *NotFoundError
+		conflictError             *ConflictError
+		preconditionFailedError   *PreconditionFailedError
+		preconditionRequiredError *PreconditionRequiredError
+		elasticsearchError        *ElasticsearchError
+		httpError                 *echo.HTTPError
+	)
+
+	switch {
+	case errors.As(err, &badRequestError):
+		status, code, detail = http.StatusBadRequest, CodeBadRequest, badRequestError.Message
+		if len(badRequestError.Errors) > 0 {
+			code, fieldErrors = CodeValidationFailed, badRequestError.Errors
 		}
-		return nil
+	case errors.As(err, &clientSideError):
+		status, code, detail = http.StatusBadRequest, CodeClientError, clientSideError.Message
+	case errors.As(err, &notFoundError):
+		status, code, detail = http.StatusNotFound, CodeNotFound, notFoundError.Message
+	case errors.Is(err, mongo.ErrNoDocuments):
+		status, code, detail = http.StatusNotFound, CodeNotFound, "Order is not found."
+	case errors.As(err, &conflictError):
+		status, code, detail = http.StatusConflict, CodeConflict, conflictError.Message
+	case mongo.IsDuplicateKeyError(err):
+		status, code, detail = http.StatusConflict, CodeDuplicateKey, "A document with the same key already exists."
+	case errors.As(err, &preconditionFailedError):
+		status, code, detail = http.StatusPreconditionFailed, CodePreconditionFailed, preconditionFailedError.Message
+	case errors.As(err, &preconditionRequiredError):
+		status, code, detail = http.StatusPreconditionRequired, CodePreconditionRequired, preconditionRequiredError.Message
+	case mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		status, code, detail = http.StatusGatewayTimeout, CodeTimeout, "The database did not answer in time."
+	case mongo.IsNetworkError(err):
+		status, code, detail = http.StatusServiceUnavailable, CodeDatabaseUnavailable, "The database is not available."
+	case errors.As(err, &elasticsearchError):
+		switch elasticsearchError.StatusCode {
+		case 0, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			status, code, detail = http.StatusServiceUnavailable, CodeSearchUnavailable, "Search is not available."
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			status, code, detail = http.StatusGatewayTimeout, CodeTimeout, "Search did not answer in time."
+		default:
+			status, code, detail = http.StatusBadGateway, CodeSearchFailed, "Search failed."
+		}
+	case errors.As(err, &httpError):
+		status, code = httpError.Code, CodeBadRequest
+		detail = http.StatusText(httpError.Code)
+		if message, ok := httpError.Message.(string); ok {
+			detail = message
+		}
+		if status == http.StatusNotFound {
+			code = CodeNotFound
+		} else if status >= http.StatusInternalServerError {
+			code = CodeInternal
+		}
+	}
+
+	return ProblemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fieldErrors,
 	}
 }
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNewProblem(t *testing.T) {
+	fieldErrors := []FieldError{{Field: "city", Rule: "required", Message: "city is required"}}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		detail string
+		errors []FieldError
+	}{
+		{"bad request", &BadRequestError{Message: "limit is too high"}, http.StatusBadRequest, CodeBadRequest, "limit is too high", nil},
+		{"bad request with field errors", &BadRequestError{Message: "request is not valid", Errors: fieldErrors}, http.StatusBadRequest, CodeValidationFailed, "request is not valid", fieldErrors},
+		{"client error", &ClientSideError{Message: "body is not json"}, http.StatusBadRequest, CodeClientError, "body is not json", nil},
+		{"not found", &NotFoundError{Message: "order-1 is not found"}, http.StatusNotFound, CodeNotFound, "order-1 is not found", nil},
+		{"no documents", mongo.ErrNoDocuments, http.StatusNotFound, CodeNotFound, "Order is not found.", nil},
+		{"wrapped error", fmt.Errorf("get order: %w", mongo.ErrNoDocuments), http.StatusNotFound, CodeNotFound, "Order is not found.", nil},
+		{"conflict", &ConflictError{Message: "key is used"}, http.StatusConflict, CodeConflict, "key is used", nil},
+		{"duplicate key", mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, http.StatusConflict, CodeDuplicateKey, "A document with the same key already exists.", nil},
+		{"precondition failed", &PreconditionFailedError{Message: "version is changed"}, http.StatusPreconditionFailed, CodePreconditionFailed, "version is changed", nil},
+		{"precondition required", &PreconditionRequiredError{Message: "If-Match is required"}, http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match is required", nil},
+		{"database timeout", context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout, "The database did not answer in time.", nil},
+		{"elasticsearch not reachable", &ElasticsearchError{Reason: "connection refused"}, http.StatusServiceUnavailable, CodeSearchUnavailable, "Search is not available.", nil},
+		{"elasticsearch too many requests", &ElasticsearchError{StatusCode: http.StatusTooManyRequests}, http.StatusServiceUnavailable, CodeSearchUnavailable, "Search is not available.", nil},
+		{"elasticsearch timeout", &ElasticsearchError{StatusCode: http.StatusRequestTimeout}, http.StatusGatewayTimeout, CodeTimeout, "Search did not answer in time.", nil},
+		{"elasticsearch failure", &ElasticsearchError{StatusCode: http.StatusBadRequest, Type: "parsing_exception"}, http.StatusBadGateway, CodeSearchFailed, "Search failed.", nil},
+		{"echo error", echo.NewHTTPError(http.StatusMethodNotAllowed, "method is not allowed"), http.StatusMethodNotAllowed, CodeBadRequest, "method is not allowed", nil},
+		{"echo not found", echo.ErrNotFound, http.StatusNotFound, CodeNotFound, "Not Found", nil},
+		{"echo server error", echo.NewHTTPError(http.StatusInternalServerError), http.StatusInternalServerError, CodeInternal, "Internal Server Error", nil},
+		// Details of unexpected errors are only logged
+		{"unknown error", errors.New("secret connection string"), http.StatusInternalServerError, CodeInternal, "Something went wrong!", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := ProblemDetails{
+				Type:   "about:blank",
+				Title:  http.StatusText(test.status),
+				Status: test.status,
+				Detail: test.detail,
+				Code:   test.code,
+				Errors: test.errors,
+			}
+			if actual := NewProblem(test.err); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %+v, got %+v", expected, actual)
+			}
+		})
+	}
+}